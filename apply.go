@@ -0,0 +1,231 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	changeCreate    = "create"
+	changeUpdate    = "update"
+	changeDelete    = "delete"
+	changeUnchanged = "unchanged"
+)
+
+type Change struct {
+	Name   string
+	UUID   string
+	Action string
+	Diff   []string
+}
+
+// applyProcesses reconciles the manager against the processes of an ecosystem,
+// it runs in the operate loop so no other operation interleaves with it.
+func (m *Manager) applyProcesses(ctx context.Context, opt *OperateApply) ([]*Change, error) {
+	err := (&Ecosystem{Processes: opt.Processes}).validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// users and groups are resolved here rather than in validate, the client
+	// loading the ecosystem may not know them.
+	for _, p := range opt.Processes {
+		err = m.admit(p.attributes(opt.Source))
+		if err != nil {
+			return nil, fmt.Errorf("process %s: %w", p.Name, err)
+		}
+	}
+
+	changes := make([]*Change, 0, len(opt.Processes))
+	wanted := make(map[string]*Attributes, len(opt.Processes))
+
+	for _, p := range opt.Processes {
+		attributes := p.attributes(opt.Source)
+		wanted[p.Name] = attributes
+
+		process := m.searchName(p.Name)
+
+		if process != nil && !opt.Force {
+			process.m.Lock()
+			source := process.attributes.source
+			process.m.Unlock()
+
+			if source != opt.Source {
+				return nil, conflict(fmt.Errorf("process %s does not belong to %s but to %q, force takes it over", p.Name, opt.Source, source))
+			}
+		}
+
+		switch {
+		case opt.Delete:
+			if process != nil {
+				changes = append(changes, &Change{Name: p.Name, UUID: process.uuid, Action: changeDelete})
+			}
+
+		case process == nil:
+			changes = append(changes, &Change{Name: p.Name, Action: changeCreate})
+
+		default:
+			process.m.Lock()
+			diff := process.attributes.diff(attributes)
+			process.m.Unlock()
+
+			action := changeUnchanged
+			if len(diff) > 0 {
+				action = changeUpdate
+			}
+
+			changes = append(changes, &Change{Name: p.Name, UUID: process.uuid, Action: action, Diff: diff})
+		}
+	}
+
+	if !opt.Delete {
+		for _, process := range m.searchSource(opt.Source) {
+			if _, ok := wanted[process.attributes.name]; !ok {
+				changes = append(changes, &Change{Name: process.attributes.name, UUID: process.uuid, Action: changeDelete})
+			}
+		}
+	}
+
+	if opt.DryRun {
+		return changes, nil
+	}
+
+	// deletes stop dependents before their dependencies.
+	var (
+		deletes   []*Change
+		positions []int
+	)
+	for i, change := range changes {
+		if change.Action == changeDelete {
+			deletes = append(deletes, change)
+			positions = append(positions, i)
+		}
+	}
+
+	names := make([]string, 0, len(deletes))
+	byName := make(map[string]*Change, len(deletes))
+	for _, change := range deletes {
+		names = append(names, change.Name)
+		byName[change.Name] = change
+	}
+
+	m.dependencyOrder(names, true)
+
+	for i, name := range names {
+		changes[positions[i]] = byName[name]
+	}
+
+	for _, change := range changes {
+		switch change.Action {
+		case changeCreate:
+			process, err := m.createProcess(wanted[change.Name])
+			if err != nil {
+				return changes, err
+			}
+
+			change.UUID = process.uuid
+
+			err = m.launchProcess(process, true)
+			if err != nil {
+				m.removeProcess(process.uuid)
+				return changes, fmt.Errorf("process %s: %w", change.Name, err)
+			}
+
+		case changeUpdate:
+			process, err := m.searchProcess(change.UUID)
+			if err != nil {
+				return changes, err
+			}
+
+			err = m.updateProcess(ctx, process, wanted[change.Name], opt.Gracefully)
+			if err != nil {
+				return changes, fmt.Errorf("process %s: %w", change.Name, err)
+			}
+
+		case changeDelete:
+			process, err := m.searchProcess(change.UUID)
+			if err != nil {
+				return changes, err
+			}
+
+			err = m.stopProcess(ctx, process, opt.Gracefully, true)
+			if err != nil {
+				return changes, fmt.Errorf("process %s: %w", change.Name, err)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// updateProcess replaces the attributes of a process and restarts it with them.
+// They are checked before the stop, a refused update leaves the process running.
+func (m *Manager) updateProcess(ctx context.Context, process *Process, attributes *Attributes, gracefully time.Duration) error {
+	m.lock.Lock()
+	err := validateDependencies(attributes.name, attributes.dependsOn, m.dependencyGraph())
+	m.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = m.stopProcess(ctx, process, gracefully, false)
+	if err != nil {
+		return err
+	}
+
+	process.m.Lock()
+	entry := process.cronEntry
+	process.cronEntry = 0
+	process.m.Unlock()
+
+	if entry != 0 {
+		m.cron.Remove(entry)
+	}
+
+	m.lock.Lock()
+	process.m.Lock()
+	process.attributes = attributes
+	process.m.Unlock()
+	m.saveState()
+	m.lock.Unlock()
+
+	return m.launchProcess(process, true)
+}
+
+func (a *Attributes) diff(other *Attributes) []string {
+	var diff []string
+
+	field := func(name, old, new string) {
+		if old != new {
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", name, old, new))
+		}
+	}
+
+	field("source", fmt.Sprintf("%q", a.source), fmt.Sprintf("%q", other.source))
+	field("dir", fmt.Sprintf("%q", a.dir), fmt.Sprintf("%q", other.dir))
+	field("cmd", fmt.Sprintf("%q", a.cmd), fmt.Sprintf("%q", other.cmd))
+	field("argv", fmt.Sprintf("%q", a.argv), fmt.Sprintf("%q", other.argv))
+	field("env", fmt.Sprintf("%q", a.env), fmt.Sprintf("%q", other.env))
+	field("files", fmt.Sprintf("%q", a.files), fmt.Sprintf("%q", other.files))
+	field("restart", fmt.Sprint(a.restart), fmt.Sprint(other.restart))
+	field("restart_policy", fmt.Sprintf("%q", a.restartPolicy), fmt.Sprintf("%q", other.restartPolicy))
+	field("health_check", fmt.Sprintf("%q", a.healthCheck), fmt.Sprintf("%q", other.healthCheck))
+	field("readiness", fmt.Sprintf("%q", a.readiness), fmt.Sprintf("%q", other.readiness))
+	field("depends_on", fmt.Sprintf("%q", formatDependencies(a.dependsOn)), fmt.Sprintf("%q", formatDependencies(other.dependsOn)))
+	field("kill_mode", fmt.Sprintf("%q", a.kill), fmt.Sprintf("%q", other.kill))
+	field("session", fmt.Sprint(a.session), fmt.Sprint(other.session))
+	field("user", fmt.Sprintf("%q", a.user), fmt.Sprintf("%q", other.user))
+	field("group", fmt.Sprintf("%q", a.group), fmt.Sprintf("%q", other.group))
+	field("groups", fmt.Sprintf("%q", a.groups), fmt.Sprintf("%q", other.groups))
+	field("limits", fmt.Sprintf("%q", formatRlimits(a.limits)), fmt.Sprintf("%q", formatRlimits(other.limits)))
+	field("cgroup", fmt.Sprintf("%q", a.cgroup), fmt.Sprintf("%q", other.cgroup))
+	field("max_memory_restart", a.maxMemoryRestart.String(), other.maxMemoryRestart.String())
+	field("max_uptime", a.maxUptime.String(), other.maxUptime.String())
+	field("cron", fmt.Sprintf("%q", a.cron), fmt.Sprintf("%q", other.cron))
+	field("capture", fmt.Sprint(a.capture), fmt.Sprint(other.capture))
+	field("rotation", fmt.Sprintf("%q", a.rotation), fmt.Sprintf("%q", other.rotation))
+	field("labels", fmt.Sprintf("%q", formatLabels(a.labels)), fmt.Sprintf("%q", formatLabels(other.labels)))
+
+	return diff
+}
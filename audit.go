@@ -0,0 +1,381 @@
+package process
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	auditOK    = "ok"
+	auditError = "error"
+
+	auditRedacted = "[redacted]"
+
+	auditMaxLine = 1 << 20
+)
+
+// AuditEntry is a line of the audit log, one rpc call. Target is the process,
+// selector or source the caller named, Processes those the call acted on.
+type AuditEntry struct {
+	Time      time.Time       `json:"time"`
+	Peer      string          `json:"peer"`
+	Address   string          `json:"address"`
+	Operation string          `json:"operation"`
+	Target    string          `json:"target,omitempty"`
+	Processes []string        `json:"processes,omitempty"`
+	Args      json.RawMessage `json:"args,omitempty"`
+	Outcome   string          `json:"outcome"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// Audit appends a json line for every rpc call to a file, rotated like the
+// captured output of a process.
+type Audit struct {
+	path string
+	w    *rotateWriter
+}
+
+func OpenAudit(path string, rotation *Rotation) (*Audit, error) {
+	if rotation != nil {
+		err := rotation.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+
+	return &Audit{
+		path: path,
+		w:    newRotateWriter(path, file, rotation),
+	}, nil
+}
+
+func (a *Audit) record(entry *AuditEntry) {
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = a.w.Write(append(data, '\n'))
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("operation", entry.Operation).Error("audit record failed")
+	}
+}
+
+func (a *Audit) Close() error {
+	return a.w.Close()
+}
+
+// AuditQuery selects entries of the audit log, zero values select any.
+// Process matches the target or a process acted on, by name or uuid.
+type AuditQuery struct {
+	Since     time.Time
+	Until     time.Time
+	Process   string
+	Operation string
+	Limit     int
+}
+
+func (q *AuditQuery) matches(entry *AuditEntry) bool {
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && entry.Time.After(q.Until) {
+		return false
+	}
+	if q.Operation != "" && entry.Operation != q.Operation {
+		return false
+	}
+	if q.Process != "" && entry.Target != q.Process && !containsString(entry.Processes, q.Process) {
+		return false
+	}
+	return true
+}
+
+// Query reads the entries matching q from the log and its rotated segments,
+// oldest first, the last Limit of them when Limit is set.
+func (a *Audit) Query(q *AuditQuery) ([]*AuditEntry, error) {
+	backups, err := filepath.Glob(a.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	paths := backups[:0]
+	for _, b := range backups {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(b, a.path+"."), ".gz")
+		if len(stamp) > len(rotateTimeFormat) {
+			stamp = stamp[:len(rotateTimeFormat)]
+		}
+		if _, err := time.Parse(rotateTimeFormat, stamp); err == nil {
+			paths = append(paths, b)
+		}
+	}
+
+	sort.Strings(paths)
+	paths = append(paths, a.path)
+
+	var entries []*AuditEntry
+	for _, path := range paths {
+		err := readAudit(path, func(entry *AuditEntry) {
+			if q.matches(entry) {
+				entries = append(entries, entry)
+			}
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+
+	return entries, nil
+}
+
+func readAudit(path string, fn func(entry *AuditEntry)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), auditMaxLine)
+
+	for scanner.Scan() {
+		entry := &AuditEntry{}
+		if json.Unmarshal(scanner.Bytes(), entry) == nil {
+			fn(entry)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// auditCodec records the calls of a connection as the server reads and
+// answers them.
+type auditCodec struct {
+	rpc.ServerCodec
+	audit *Audit
+	peer  *Peer
+
+	m       sync.Mutex
+	reading *AuditEntry
+	calls   map[uint64]*AuditEntry
+}
+
+func newAuditCodec(codec rpc.ServerCodec, audit *Audit, peer *Peer) *auditCodec {
+	return &auditCodec{
+		ServerCodec: codec,
+		audit:       audit,
+		peer:        peer,
+		calls:       make(map[uint64]*AuditEntry),
+	}
+}
+
+func (c *auditCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err != nil {
+		return err
+	}
+
+	operation := r.ServiceMethod
+	if i := strings.LastIndex(operation, "."); i >= 0 {
+		operation = operation[i+1:]
+	}
+
+	entry := &AuditEntry{
+		Time:      time.Now(),
+		Peer:      c.peer.String(),
+		Address:   c.peer.Address,
+		Operation: strings.ToLower(operation),
+	}
+
+	c.m.Lock()
+	c.reading = entry
+	c.calls[r.Seq] = entry
+	c.m.Unlock()
+
+	return nil
+}
+
+func (c *auditCodec) ReadRequestBody(body interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+
+	c.m.Lock()
+	entry := c.reading
+	c.reading = nil
+	c.m.Unlock()
+
+	if err == nil && body != nil && entry != nil {
+		entry.Args, entry.Target = auditArgs(body)
+	}
+
+	return err
+}
+
+func (c *auditCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.m.Lock()
+	entry := c.calls[r.Seq]
+	delete(c.calls, r.Seq)
+	c.m.Unlock()
+
+	if entry != nil {
+		entry.Outcome = auditOK
+		entry.Error = r.Error
+
+		var failed []string
+		entry.Processes, failed = auditProcesses(body)
+		if entry.Error == "" && len(failed) > 0 {
+			entry.Error = strings.Join(failed, "; ")
+		}
+		if entry.Error != "" {
+			entry.Outcome = auditError
+		}
+
+		c.audit.record(entry)
+	}
+
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// auditArgs returns the arguments of a call with the values of env entries
+// redacted, and the process, selector or source they name.
+func auditArgs(argv interface{}) (json.RawMessage, string) {
+	data, err := json.Marshal(argv)
+	if err != nil {
+		return nil, ""
+	}
+
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	if err != nil {
+		return nil, ""
+	}
+
+	redactEnv(v)
+
+	data, err = json.Marshal(v)
+	if err != nil {
+		return nil, ""
+	}
+
+	target := ""
+	if fields, ok := v.(map[string]interface{}); ok {
+		for _, key := range []string{"ID", "UUID", "Name", "Selector", "Source"} {
+			if s, ok := fields[key].(string); ok && s != "" {
+				target = s
+				break
+			}
+		}
+	}
+
+	return data, target
+}
+
+// redactEnv replaces the values of KEY=value entries under any env key.
+func redactEnv(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			env, ok := value.([]interface{})
+			if !strings.EqualFold(key, "env") || !ok {
+				redactEnv(value)
+				continue
+			}
+
+			for i, e := range env {
+				if s, ok := e.(string); ok {
+					if j := strings.Index(s, "="); j >= 0 {
+						env[i] = s[:j+1] + auditRedacted
+					}
+				}
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			redactEnv(value)
+		}
+	}
+}
+
+// auditProcesses returns the names of the processes a call acted on, and the
+// errors of those it failed for.
+func auditProcesses(reply interface{}) ([]string, []string) {
+	var results []*Result
+
+	switch reply := reply.(type) {
+	case *StartReply:
+		switch {
+		case reply.Name != "":
+			return []string{reply.Name}, nil
+		case reply.UUID != "":
+			return []string{reply.UUID}, nil
+		}
+		return nil, nil
+	case *WaitReply:
+		switch {
+		case reply.Name != "":
+			return []string{reply.Name}, nil
+		case reply.UUID != "":
+			return []string{reply.UUID}, nil
+		}
+		return nil, nil
+	case *ApplyReply:
+		var names []string
+		for _, change := range reply.Changes {
+			names = append(names, change.Name)
+		}
+		return names, nil
+	case *KillReply:
+		results = reply.Results
+	case *StopReply:
+		results = reply.Results
+	case *RestartReply:
+		results = reply.Results
+	case *SignalReply:
+		results = reply.Results
+	default:
+		return nil, nil
+	}
+
+	var names, failed []string
+	for _, result := range results {
+		name := result.Name
+		if name == "" {
+			name = result.UUID
+		}
+
+		names = append(names, name)
+		if result.Error != "" {
+			failed = append(failed, name+": "+result.Error)
+		}
+	}
+
+	return names, failed
+}
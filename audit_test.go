@@ -0,0 +1,170 @@
+package process
+
+import (
+	"encoding/json"
+	"io"
+	"net/rpc"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// replayCodec hands out the calls one by one and drops the responses.
+type replayCodec struct {
+	methods []string
+	args    []interface{}
+	next    int
+}
+
+func (c *replayCodec) ReadRequestHeader(r *rpc.Request) error {
+	if c.next >= len(c.methods) {
+		return io.EOF
+	}
+	r.ServiceMethod = c.methods[c.next]
+	r.Seq = uint64(c.next)
+	return nil
+}
+
+func (c *replayCodec) ReadRequestBody(body interface{}) error {
+	data, err := json.Marshal(c.args[c.next])
+	c.next++
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, body)
+}
+
+func (c *replayCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	return nil
+}
+
+func (c *replayCodec) Close() error {
+	return nil
+}
+
+func TestAuditCodec(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		args      interface{}
+		argv      interface{}
+		reply     interface{}
+		err       string
+		target    string
+		processes []string
+		outcome   string
+		error     string
+	}{
+		{
+			name:      "start",
+			method:    "RPC.Start",
+			args:      &StartArgv{Name: "web", Cmd: "/usr/bin/web", Env: []string{"TOKEN=secret"}},
+			argv:      &StartArgv{},
+			reply:     &StartReply{UUID: "1", Name: "web", Pid: 10},
+			target:    "web",
+			processes: []string{"web"},
+			outcome:   auditOK,
+		},
+		{
+			name:      "start without a name",
+			method:    "RPC.Start",
+			args:      &StartArgv{Cmd: "/usr/bin/web"},
+			argv:      &StartArgv{},
+			reply:     &StartReply{UUID: "2", Pid: 11},
+			processes: []string{"2"},
+			outcome:   auditOK,
+		},
+		{
+			name:    "refused",
+			method:  "RPC.Start",
+			args:    &StartArgv{Name: "db", Cmd: "/bin/sh"},
+			argv:    &StartArgv{},
+			reply:   &StartReply{},
+			err:     "permission denied",
+			target:  "db",
+			outcome: auditError,
+			error:   "permission denied",
+		},
+		{
+			name:   "stop by selector",
+			method: "RPC.Stop",
+			args:   &StopArgv{Selector: "tier=web"},
+			argv:   &StopArgv{},
+			reply: &StopReply{Results: []*Result{
+				{UUID: "1", Name: "web"},
+				{UUID: "3", Error: "not running"},
+			}},
+			target:    "tier=web",
+			processes: []string{"web", "3"},
+			outcome:   auditError,
+			error:     "3: not running",
+		},
+	}
+
+	codec := &replayCodec{}
+	for _, test := range tests {
+		codec.methods = append(codec.methods, test.method)
+		codec.args = append(codec.args, test.args)
+	}
+
+	audit, err := OpenAudit(filepath.Join(t.TempDir(), "audit.log"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	peer := &Peer{Address: "127.0.0.1:1234", Token: "ci"}
+	c := newAuditCodec(codec, audit, peer)
+
+	for _, test := range tests {
+		var r rpc.Request
+		if err := c.ReadRequestHeader(&r); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.ReadRequestBody(test.argv); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WriteResponse(&rpc.Response{ServiceMethod: r.ServiceMethod, Seq: r.Seq, Error: test.err}, test.reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := audit.Query(&AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(tests) {
+		t.Fatalf("%d entries, want %d", len(entries), len(tests))
+	}
+
+	for i, test := range tests {
+		entry := entries[i]
+
+		operation := strings.ToLower(strings.TrimPrefix(test.method, "RPC."))
+		if entry.Operation != operation || entry.Target != test.target || !reflect.DeepEqual(entry.Processes, test.processes) {
+			t.Errorf("%s: %s of %q on %v, want %s of %q on %v", test.name, entry.Operation, entry.Target, entry.Processes, operation, test.target, test.processes)
+		}
+		if entry.Outcome != test.outcome || entry.Error != test.error {
+			t.Errorf("%s: outcome %s %q, want %s %q", test.name, entry.Outcome, entry.Error, test.outcome, test.error)
+		}
+		if entry.Peer != peer.String() || entry.Address != peer.Address {
+			t.Errorf("%s: peer %s at %s", test.name, entry.Peer, entry.Address)
+		}
+		if strings.Contains(string(entry.Args), "secret") {
+			t.Errorf("%s: env not redacted in %s", test.name, entry.Args)
+		}
+	}
+
+	if !strings.Contains(string(entries[0].Args), `"TOKEN=`+auditRedacted+`"`) {
+		t.Errorf("env not redacted in %s", entries[0].Args)
+	}
+
+	entries, err = audit.Query(&AuditQuery{Process: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d entries for web, want the start and the stop", len(entries))
+	}
+}
@@ -0,0 +1,420 @@
+package process
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// authTimeout bounds the handshake of a connection, the tls handshake and the
+// token exchange.
+const authTimeout = time.Second * 10
+
+var errUnauthenticated = errors.New("unauthenticated")
+
+// Peer is the authenticated caller of a connection.
+type Peer struct {
+	Address    string
+	CommonName string
+	Token      string
+	Credential *PeerCredential
+}
+
+func (p *Peer) String() string {
+	switch {
+	case p.Credential != nil:
+		return fmt.Sprintf("uid=%d,gid=%d,pid=%d@%s", p.Credential.UID, p.Credential.GID, p.Credential.PID, p.Address)
+	case p.CommonName != "":
+		return fmt.Sprintf("cn=%s@%s", p.CommonName, p.Address)
+	case p.Token != "":
+		return fmt.Sprintf("token=%s@%s", p.Token, p.Address)
+	default:
+		return p.Address
+	}
+}
+
+type peerKey struct{}
+
+// WithPeer returns a context that carries the caller of the operations run
+// with it.
+func WithPeer(ctx context.Context, peer *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+// PeerFromContext returns the caller carried by ctx, nil for the manager
+// itself.
+func PeerFromContext(ctx context.Context) *Peer {
+	peer, _ := ctx.Value(peerKey{}).(*Peer)
+	return peer
+}
+
+// defaultToken names the token of Auth, tokens of a policy have names of
+// their own.
+const defaultToken = "default"
+
+// Auth authenticates the connections of the rpc service. With TLS the
+// connection is encrypted and, given client CAs, needs a verified client
+// certificate. With Token or the tokens of Policy the client has to present
+// a token before its first call.
+type Auth struct {
+	TLS    *tls.Config
+	Token  string
+	Policy *Policy
+}
+
+func (a *Auth) tokens() bool {
+	return a.Token != "" || a.Policy != nil && len(a.Policy.Tokens) > 0
+}
+
+// token returns the name of the token, empty for a token neither Token nor
+// the policy has.
+func (a *Auth) token(token string) string {
+	if a.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1 {
+		return defaultToken
+	}
+	if a.Policy != nil && token != "" {
+		return a.Policy.token(token)
+	}
+	return ""
+}
+
+// authenticates tells whether a connection proves who the caller is, a TLS
+// config without client CAs only encrypts.
+func (a *Auth) authenticates() bool {
+	if a == nil {
+		return false
+	}
+	return a.tokens() || a.TLS != nil && a.TLS.ClientAuth == tls.RequireAndVerifyClientCert
+}
+
+// ServerTLS loads the certificate of the service, with ca clients need a
+// certificate signed by it.
+func ServerTLS(cert, key, ca string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if ca != "" {
+		config.ClientCAs, err = loadCertPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// ClientTLS verifies the service against ca, or the system roots without it.
+// A client certificate is only presented when cert and key are given.
+func ClientTLS(cert, key, ca string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cert != "" || key != "" {
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("load tls certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if ca != "" {
+		pool, err := loadCertPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load tls ca: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("load tls ca: no certificate in %s", path)
+	}
+
+	return pool, nil
+}
+
+// authRequest and authReply are the token exchange, a line of json each way
+// before the json-rpc stream starts.
+type authRequest struct {
+	Token string `json:"token"`
+}
+
+type authReply struct {
+	Error string `json:"error,omitempty"`
+}
+
+// callPrefix starts every json-rpc request, which tells a call from the token
+// line.
+const callPrefix = `{"method"`
+
+// bufferedConn reads through the reader of the token exchange, which may hold
+// bytes read past its line.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// authenticate runs the handshake of a new connection, a connection that fails
+// it is closed by the caller before any call is read.
+func (a *Auth) authenticate(conn net.Conn) (net.Conn, *Peer, error) {
+	peer := &Peer{Address: conn.RemoteAddr().String()}
+
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		peer.Address = "unix"
+
+		credential, err := peerCredential(unixConn)
+		if err != nil {
+			return nil, nil, fmt.Errorf("peer credential: %w", err)
+		}
+		peer.Credential = credential
+	}
+
+	if a == nil {
+		return conn, peer, nil
+	}
+
+	err := conn.SetDeadline(time.Now().Add(authTimeout))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if a.TLS != nil {
+		tlsConn := tls.Server(conn, a.TLS)
+
+		err := tlsConn.Handshake()
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls handshake: %w", err)
+		}
+
+		state := tlsConn.ConnectionState()
+		if len(state.VerifiedChains) > 0 {
+			peer.CommonName = state.PeerCertificates[0].Subject.CommonName
+		}
+
+		conn = tlsConn
+	}
+
+	if a.tokens() {
+		r := bufio.NewReader(conn)
+
+		// a unix socket peer is known by its credentials, it may start with
+		// its first call right away.
+		if peer.Credential != nil {
+			prefix, err := r.Peek(len(callPrefix))
+			if err == nil && string(prefix) == callPrefix {
+				return &bufferedConn{Conn: conn, r: r}, peer, conn.SetDeadline(time.Time{})
+			}
+		}
+
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("read token: %w", err)
+		}
+
+		request := &authRequest{}
+		_ = json.Unmarshal(line, request)
+
+		name := a.token(request.Token)
+
+		reply := &authReply{}
+		if name == "" {
+			reply.Error = "invalid token"
+		}
+
+		err = writeJSONLine(conn, reply)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if reply.Error != "" {
+			return nil, nil, fmt.Errorf("%w: %s", errUnauthenticated, reply.Error)
+		}
+
+		peer.Token = name
+		conn = &bufferedConn{Conn: conn, r: r}
+	}
+
+	return conn, peer, conn.SetDeadline(time.Time{})
+}
+
+// Serve accepts connections on listener until ctx is done and serves the rpc
+// service on those that pass the handshake of auth, nil accepts any.
+func (r *RPC) Serve(ctx context.Context, listener net.Listener, auth *Auth) error {
+	err := checkExposure("rpc", listener, auth)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		go func() {
+			authenticated, peer, err := auth.authenticate(conn)
+			if err != nil {
+				logrus.WithError(err).WithField("address", conn.RemoteAddr().String()).Warn("rpc connection rejected")
+				_ = conn.Close()
+				return
+			}
+
+			logrus.WithField("peer", peer.String()).Debug("rpc connection accepted")
+
+			// every connection has a server of its own, the methods know
+			// their caller.
+			server := rpc.NewServer()
+
+			err = server.Register(r.session(peer))
+			if err != nil {
+				logrus.WithError(err).Error("rpc register failed")
+				_ = authenticated.Close()
+				return
+			}
+
+			codec := jsonrpc.NewServerCodec(authenticated)
+			if r.audit != nil {
+				codec = newAuditCodec(codec, r.audit, peer)
+			}
+
+			server.ServeCodec(codec)
+		}()
+	}
+}
+
+// checkExposure refuses to serve unauthenticated callers on a tcp address other
+// than loopback, anyone who can reach it could run commands as the service.
+func checkExposure(service string, listener net.Listener, auth *Auth) error {
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok || auth.authenticates() {
+		return nil
+	}
+
+	if !addr.IP.IsLoopback() {
+		_ = listener.Close()
+		return fmt.Errorf("%s service on %s would accept unauthenticated connections, listen on loopback or set a token or a client ca", service, addr)
+	}
+
+	logrus.WithField("address", addr.String()).Warnf("%s service accepts unauthenticated connections", service)
+
+	return nil
+}
+
+// Dial connects to the rpc service and runs the handshake of auth, nil for a
+// plain connection. The service is verified against the TLS config.
+func Dial(network, address string, auth *Auth) (*rpc.Client, error) {
+	dialer := &net.Dialer{Timeout: authTimeout}
+
+	if auth == nil {
+		conn, err := dialer.Dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return jsonrpc.NewClient(conn), nil
+	}
+
+	var conn net.Conn
+	var err error
+
+	if auth.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, network, address, auth.TLS)
+	} else {
+		conn, err = dialer.Dial(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.Token != "" {
+		conn, err = presentToken(conn, auth.Token)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return jsonrpc.NewClient(conn), nil
+}
+
+func presentToken(conn net.Conn, token string) (net.Conn, error) {
+	err := conn.SetDeadline(time.Now().Add(authTimeout))
+	if err != nil {
+		return conn, err
+	}
+
+	err = writeJSONLine(conn, &authRequest{Token: token})
+	if err != nil {
+		return conn, err
+	}
+
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return conn, fmt.Errorf("read token reply: %w", err)
+	}
+
+	reply := &authReply{}
+	err = json.Unmarshal(line, reply)
+	if err != nil {
+		return conn, fmt.Errorf("read token reply: %w", err)
+	}
+
+	if reply.Error != "" {
+		return conn, fmt.Errorf("%w: %s", errUnauthenticated, reply.Error)
+	}
+
+	return &bufferedConn{Conn: conn, r: r}, conn.SetDeadline(time.Time{})
+}
+
+func writeJSONLine(conn net.Conn, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,261 @@
+package process
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cpuPeriod is the cpu.max period, quotas are given in CPUs of it.
+	cpuPeriod = 100000
+
+	cgroupKillWait = time.Second
+)
+
+// Cgroup holds the cgroup v2 limits of a process, a zero value leaves the
+// limit unset. CPUMax is in CPUs, 1.5 allows one and a half.
+type Cgroup struct {
+	MemoryMax  Size    `json:"memory_max" yaml:"memory_max" toml:"memory_max"`
+	MemoryHigh Size    `json:"memory_high" yaml:"memory_high" toml:"memory_high"`
+	CPUMax     float64 `json:"cpu_max" yaml:"cpu_max" toml:"cpu_max"`
+	PidsMax    int64   `json:"pids_max" yaml:"pids_max" toml:"pids_max"`
+}
+
+func (c *Cgroup) String() string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("memory_max=%s memory_high=%s cpu_max=%g pids_max=%d", c.MemoryMax, c.MemoryHigh, c.CPUMax, c.PidsMax)
+}
+
+func (c *Cgroup) validate() error {
+	if c.MemoryMax < 0 || c.MemoryHigh < 0 || c.CPUMax < 0 || c.PidsMax < 0 {
+		return fmt.Errorf("invalid cgroup: %s", c)
+	}
+	if c.MemoryMax > 0 && c.MemoryHigh > c.MemoryMax {
+		return fmt.Errorf("invalid cgroup: %s, memory_high above memory_max", c)
+	}
+	return nil
+}
+
+// files returns the values of the cgroup interface files, max for the limits
+// left unset so a changed definition drops them.
+func (c *Cgroup) files() map[string]string {
+	files := map[string]string{
+		"memory.max":  "max",
+		"memory.high": "max",
+		"cpu.max":     fmt.Sprintf("max %d", cpuPeriod),
+		"pids.max":    "max",
+	}
+
+	if c == nil {
+		return files
+	}
+
+	if c.MemoryMax > 0 {
+		files["memory.max"] = strconv.FormatInt(int64(c.MemoryMax), 10)
+	}
+	if c.MemoryHigh > 0 {
+		files["memory.high"] = strconv.FormatInt(int64(c.MemoryHigh), 10)
+	}
+	if c.CPUMax > 0 {
+		files["cpu.max"] = fmt.Sprintf("%d %d", int64(c.CPUMax*cpuPeriod), cpuPeriod)
+	}
+	if c.PidsMax > 0 {
+		files["pids.max"] = strconv.FormatInt(c.PidsMax, 10)
+	}
+
+	return files
+}
+
+// cgroupStats is the accounting of a cgroup, covering the whole subtree.
+type cgroupStats struct {
+	memoryCurrent Size
+	cpuUsage      time.Duration
+	cpuThrottled  time.Duration
+	oomKills      int64
+}
+
+// prepareCgroupParent checks the parent is a cgroup v2 directory and enables
+// the controllers the limits need for its children. A controller that is not
+// delegated only fails the processes limiting it.
+func prepareCgroupParent(parent string) error {
+	_, err := os.Stat(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("cgroup parent %s is not a cgroup v2 directory: %w", parent, err)
+	}
+
+	for _, controller := range []string{"cpu", "memory", "pids"} {
+		err := writeCgroupFile(parent, "cgroup.subtree_control", "+"+controller)
+		if err != nil {
+			logrus.WithError(err).WithField("controller", controller).Warn("enable cgroup controller failed")
+		}
+	}
+
+	return nil
+}
+
+// cgroup returns the cgroup of the process, empty without a cgroup parent.
+func (p *Process) cgroup() string {
+	if p.manager.cgroupParent == "" {
+		return ""
+	}
+	return filepath.Join(p.manager.cgroupParent, p.uuid)
+}
+
+func setupCgroup(path string, c *Cgroup) error {
+	err := os.Mkdir(path, 0755)
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("create cgroup %s: %w", path, err)
+	}
+
+	for file, value := range c.files() {
+		err := writeCgroupFile(path, file, value)
+		if err == nil {
+			continue
+		}
+
+		// without the controller the file is missing, which only matters
+		// for a limit that is set.
+		if strings.HasPrefix(value, "max") && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		return fmt.Errorf("set cgroup %s: %w", file, err)
+	}
+
+	return nil
+}
+
+// killCgroup kills every process in the cgroup, through cgroup.kill where the
+// kernel has it, and waits a moment for the cgroup to empty.
+func killCgroup(path string) ([]int, error) {
+	pids, err := cgroupPids(path)
+	if err != nil || len(pids) == 0 {
+		return nil, err
+	}
+
+	killed := pids
+
+	err = writeCgroupFile(path, "cgroup.kill", "1")
+	if err != nil {
+		killed = nil
+		for _, pid := range pids {
+			if syscall.Kill(pid, syscall.SIGKILL) == nil {
+				killed = append(killed, pid)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(cgroupKillWait)
+	for time.Now().Before(deadline) {
+		left, err := cgroupPids(path)
+		if err != nil || len(left) == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	return killed, nil
+}
+
+// removeCgroup removes the cgroup of a removed process, it is left behind
+// while processes remain in it.
+func removeCgroup(path string) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).WithField("cgroup", path).Warn("remove cgroup failed")
+	}
+}
+
+func cgroupPids(path string) ([]int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, "cgroup.procs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(field)
+		if err == nil {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
+
+// readCgroupStats reads the accounting of the cgroup, files of controllers
+// that are not enabled count as zero.
+func readCgroupStats(path string) *cgroupStats {
+	stats := &cgroupStats{}
+
+	data, err := ioutil.ReadFile(filepath.Join(path, "memory.current"))
+	if err == nil {
+		n, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		stats.memoryCurrent = Size(n)
+	}
+
+	cpu := readCgroupKeyed(filepath.Join(path, "cpu.stat"))
+	stats.cpuUsage = time.Duration(cpu["usage_usec"]) * time.Microsecond
+	stats.cpuThrottled = time.Duration(cpu["throttled_usec"]) * time.Microsecond
+
+	stats.oomKills = readCgroupKeyed(filepath.Join(path, "memory.events"))["oom_kill"]
+
+	return stats
+}
+
+func readCgroupKeyed(path string) map[string]int64 {
+	values := make(map[string]int64)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err == nil {
+			values[fields[0]] = n
+		}
+	}
+
+	return values
+}
+
+// writeCgroupFile writes an interface file, which is never created: the
+// kernel refuses that with EACCES, a missing file has to show as such.
+func writeCgroupFile(path, file, value string) error {
+	f, err := os.OpenFile(filepath.Join(path, file), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(value)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"net/rpc"
-	"net/rpc/jsonrpc"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -19,6 +22,8 @@ import (
 )
 
 func main() {
+	process.RunShim()
+
 	cobra.OnInitialize(func() {
 		logrus.SetLevel(logrus.TraceLevel)
 		logrus.SetFormatter(&logrus.JSONFormatter{})
@@ -36,10 +41,21 @@ func main() {
 		newStopCommand(),
 		newRestartCommand(),
 		newSignalCommand(),
+		newEcosystemCommand("apply", false, false),
+		newEcosystemCommand("diff", false, true),
+		newEcosystemCommand("delete", true, false),
+		newLogsCommand(),
+		newWaitCommand(),
+		newTopCommand(),
+		newAuditCommand(),
 	)
 
 	root.PersistentFlags().String("network", "tcp", "net listen network")
-	root.PersistentFlags().String("address", "0.0.0.0:8080", "net listen address")
+	root.PersistentFlags().String("address", "127.0.0.1:8080", "net listen address, other than loopback the service needs --token or --tls-ca; the unix socket defaults to $XDG_RUNTIME_DIR/process.sock or /run/process.sock for root")
+	root.PersistentFlags().String("tls-cert", "", "tls certificate, of the service or the client certificate of a command")
+	root.PersistentFlags().String("tls-key", "", "tls key of --tls-cert")
+	root.PersistentFlags().String("tls-ca", "", "tls ca, the service requires client certificates signed by it, commands verify the service against it instead of the system roots")
+	root.PersistentFlags().String("token", "", "token the service requires before any call, defaults to $PROCESS_TOKEN")
 
 	cobra.CheckErr(root.Execute())
 }
@@ -48,56 +64,181 @@ func newServiceCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "service",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			network, err := cmd.Flags().GetString("network")
+			network, address, err := getAddress(cmd)
 			if err != nil {
 				return err
 			}
 
-			address, err := cmd.Flags().GetString("address")
+			state, err := cmd.Flags().GetString("state")
 			if err != nil {
 				return err
 			}
 
-			manager := process.NewManager()
+			relaunch, err := cmd.Flags().GetBool("relaunch")
+			if err != nil {
+				return err
+			}
 
-			service := process.NewRPC(manager)
+			cgroupParent, err := cmd.Flags().GetString("cgroup-parent")
+			if err != nil {
+				return err
+			}
 
-			g, ctx := errgroup.WithContext(cmd.Context())
+			statsDescendants, err := cmd.Flags().GetBool("stats-descendants")
+			if err != nil {
+				return err
+			}
 
-			g.Go(func() error {
-				logrus.Debug("manager service run")
-				return manager.Run(ctx)
-			})
+			metricsAddress, err := cmd.Flags().GetString("metrics-address")
+			if err != nil {
+				return err
+			}
 
-			g.Go(func() error {
-				err = rpc.Register(service)
+			httpAddress, err := cmd.Flags().GetString("http-address")
+			if err != nil {
+				return err
+			}
+
+			auth, err := getAuth(cmd, true)
+			if err != nil {
+				return err
+			}
+
+			socketMode, err := cmd.Flags().GetString("socket-mode")
+			if err != nil {
+				return err
+			}
+
+			mode, err := strconv.ParseUint(socketMode, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid socket mode: %s", socketMode)
+			}
+
+			socketOwner, err := cmd.Flags().GetString("socket-owner")
+			if err != nil {
+				return err
+			}
+
+			peerRules, err := getPeerRules(cmd)
+			if err != nil {
+				return err
+			}
+
+			policyFile, err := cmd.Flags().GetString("policy")
+			if err != nil {
+				return err
+			}
+
+			var policy *process.Policy
+			if policyFile != "" {
+				policy, err = process.LoadPolicy(policyFile)
 				if err != nil {
 					return err
 				}
 
-				listener, err := net.Listen(network, address)
+				if auth == nil {
+					auth = &process.Auth{}
+				}
+				auth.Policy = policy
+			}
+
+			auditLog, err := cmd.Flags().GetString("audit-log")
+			if err != nil {
+				return err
+			}
+
+			auditRotation, err := getRotation(cmd, "audit-")
+			if err != nil {
+				return err
+			}
+
+			var audit *process.Audit
+			if auditLog != "" {
+				audit, err = process.OpenAudit(auditLog, auditRotation)
 				if err != nil {
 					return err
 				}
+				defer audit.Close()
+			}
+
+			manager := process.NewManager(
+				process.WithState(state),
+				process.WithRelaunch(relaunch),
+				process.WithCgroupParent(cgroupParent),
+				process.WithStatsDescendants(statsDescendants),
+			)
+
+			service := process.NewRPC(manager, process.WithPeerRules(peerRules), process.WithPolicy(policy), process.WithAudit(audit))
+
+			g, ctx := errgroup.WithContext(cmd.Context())
+
+			g.Go(func() error {
+				logrus.Debug("manager service run")
+				return manager.Run(ctx)
+			})
+
+			if metricsAddress != "" {
+				g.Go(func() error {
+					listener, err := net.Listen("tcp", metricsAddress)
+					if err != nil {
+						return err
+					}
+
+					logrus.WithField("address", metricsAddress).Debug("metrics service run")
 
-				logrus.Debug("rpc service run")
+					return process.ServeMetrics(ctx, manager, listener)
+				})
+			}
 
-				for ctx.Err() == nil {
-					conn, err := listener.Accept()
+			if httpAddress != "" {
+				g.Go(func() error {
+					listener, err := net.Listen("tcp", httpAddress)
 					if err != nil {
 						return err
 					}
 
-					go rpc.ServeCodec(jsonrpc.NewServerCodec(conn))
+					logrus.WithField("address", httpAddress).Debug("rest service run")
+
+					return service.ServeREST(ctx, listener, auth)
+				})
+			}
+
+			g.Go(func() error {
+				var listener net.Listener
+				if network == "unix" {
+					listener, err = process.ListenSocket(address, os.FileMode(mode), socketOwner)
+				} else {
+					listener, err = net.Listen(network, address)
+				}
+				if err != nil {
+					return err
 				}
 
-				return ctx.Err()
+				logrus.WithField("address", address).Debug("rpc service run")
+
+				return service.Serve(ctx, listener, auth)
 			})
 
 			return g.Wait()
 		},
 	}
 
+	cmd.Flags().String("state", "", "state file, process definitions are restored from it on start")
+	cmd.Flags().Bool("relaunch", true, "relaunch restored processes")
+	cmd.Flags().String("metrics-address", "", "serve Prometheus metrics on this address under /metrics, e.g. 127.0.0.1:9100; they are not authenticated, so only on loopback")
+	cmd.Flags().String("http-address", "", "serve the REST API on this address under /v1, e.g. 127.0.0.1:8081; it shares the tls and token settings of the rpc service")
+	cmd.Flags().Bool("stats-descendants", false, "sum the cpu, memory, fd and io usage of a process over its descendants")
+	cmd.Flags().String("cgroup-parent", "", "delegated cgroup v2 directory, every process gets a cgroup of its own below it")
+	cmd.Flags().String("socket-mode", "0660", "mode of the unix socket")
+	cmd.Flags().String("socket-owner", "", "owner of the unix socket as user[:group], names or ids")
+	cmd.Flags().String("audit-log", "", "append a json line for every rpc call to this file")
+	cmd.Flags().String("audit-rotate-size", "", "rotate the audit log once larger than this size, e.g. 100MiB")
+	cmd.Flags().Duration("audit-rotate-interval", 0, "rotate the audit log once older than this interval")
+	cmd.Flags().Int("audit-rotate-backups", 0, "rotated audit logs to keep, 0 keeps all")
+	cmd.Flags().Bool("audit-rotate-compress", false, "gzip rotated audit logs")
+	cmd.Flags().String("policy", "", "access policy file, .yaml, .toml or .json, binding certificate, token and unix socket callers to roles; it replaces --socket-allow")
+	cmd.Flags().StringArray("socket-allow", nil, "operations unix socket peers other than root and the service user may run, as user:name=list,logs, uid:, group: or gid:, * for all, repeatable; a peer starts processes as its own user only")
+
 	return cmd
 }
 
@@ -105,26 +246,35 @@ func newListCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "list",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			network, err := cmd.Flags().GetString("network")
+			client, err := dial(cmd)
+			if err != nil {
+				return err
+			}
+
+			selector, err := cmd.Flags().GetString("selector")
 			if err != nil {
 				return err
 			}
 
-			address, err := cmd.Flags().GetString("address")
+			c, err := cmd.Flags().GetString("cmd")
 			if err != nil {
 				return err
 			}
 
-			conn, err := net.Dial(network, address)
+			status, err := cmd.Flags().GetString("status")
 			if err != nil {
 				return err
 			}
 
-			argv := &process.ListArgv{}
+			argv := &process.ListArgv{
+				Cmd:      c,
+				Status:   status,
+				Selector: selector,
+			}
 
 			reply := &process.ListReply{}
 
-			err = rpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn)).Call("RPC.List", argv, reply)
+			err = client.Call("RPC.List", argv, reply)
 			if err != nil {
 				return err
 			}
@@ -169,6 +319,10 @@ func newListCommand() *cobra.Command {
 		},
 	}
 
+	cmd.Flags().StringP("selector", "l", "", "label selector, e.g. env=prod,tier!=batch")
+	cmd.Flags().String("cmd", "", "command path or base name")
+	cmd.Flags().String("status", "", "status: created, starting, running, stopping, exited, failed, backoff, scheduled or fatal")
+
 	return cmd
 }
 
@@ -176,12 +330,7 @@ func newStartCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "start",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			network, err := cmd.Flags().GetString("network")
-			if err != nil {
-				return err
-			}
-
-			address, err := cmd.Flags().GetString("address")
+			name, err := cmd.Flags().GetString("name")
 			if err != nil {
 				return err
 			}
@@ -221,34 +370,188 @@ func newStartCommand() *cobra.Command {
 				return err
 			}
 
-			conn, err := net.Dial(network, address)
+			labels, err := cmd.Flags().GetStringToString("labels")
+			if err != nil {
+				return err
+			}
+
+			capture, err := cmd.Flags().GetBool("capture")
+			if err != nil {
+				return err
+			}
+
+			rotation, err := getRotation(cmd, "")
+			if err != nil {
+				return err
+			}
+
+			restartPolicy, err := getRestartPolicy(cmd)
+			if err != nil {
+				return err
+			}
+
+			healthCheck, err := getHealthCheck(cmd)
+			if err != nil {
+				return err
+			}
+
+			readiness, err := getReadiness(cmd)
+			if err != nil {
+				return err
+			}
+
+			dependsOn, err := getDependsOn(cmd)
+			if err != nil {
+				return err
+			}
+
+			killMode, err := cmd.Flags().GetString("kill-mode")
+			if err != nil {
+				return err
+			}
+
+			session, err := cmd.Flags().GetBool("session")
+			if err != nil {
+				return err
+			}
+
+			user, err := cmd.Flags().GetString("user")
+			if err != nil {
+				return err
+			}
+
+			group, err := cmd.Flags().GetString("group")
+			if err != nil {
+				return err
+			}
+
+			groups, err := cmd.Flags().GetStringSlice("groups")
+			if err != nil {
+				return err
+			}
+
+			limits, err := getRlimits(cmd)
+			if err != nil {
+				return err
+			}
+
+			cgroup, err := getCgroup(cmd)
+			if err != nil {
+				return err
+			}
+
+			memory, err := cmd.Flags().GetString("max-memory-restart")
+			if err != nil {
+				return err
+			}
+
+			var maxMemoryRestart process.Size
+			if memory != "" {
+				maxMemoryRestart, err = process.ParseSize(memory)
+				if err != nil {
+					return err
+				}
+			}
+
+			maxUptime, err := cmd.Flags().GetDuration("max-uptime")
+			if err != nil {
+				return err
+			}
+
+			client, err := dial(cmd)
 			if err != nil {
 				return err
 			}
 
 			argv := &process.StartArgv{
-				Dir:     dir,
-				Cmd:     c,
-				Argv:    v,
-				Env:     env,
-				Files:   files,
-				Restart: restart,
-				Cron:    cron,
+				Name:     name,
+				Dir:      dir,
+				Cmd:      c,
+				Argv:     v,
+				Env:      env,
+				Files:    files,
+				Restart:  restart,
+				Cron:     cron,
+				Labels:   labels,
+				Capture:  capture,
+				Rotation: rotation,
+
+				RestartPolicy: restartPolicy,
+				HealthCheck:   healthCheck,
+				Readiness:     readiness,
+				DependsOn:     dependsOn,
+				KillMode:      killMode,
+				Session:       session,
+				User:          user,
+				Group:         group,
+				Groups:        groups,
+				Limits:        limits,
+				Cgroup:        cgroup,
+
+				MaxMemoryRestart: maxMemoryRestart,
+				MaxUptime:        maxUptime,
 			}
 
 			reply := &process.StartReply{}
 
-			return rpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn)).Call("RPC.Start", argv, reply)
+			err = client.Call("RPC.Start", argv, reply)
+			if err != nil {
+				return err
+			}
+
+			return renderResults([]*process.Result{{UUID: reply.UUID, Name: reply.Name, Pid: reply.Pid}})
 		},
 	}
 
+	cmd.Flags().String("name", "", "unique name, usable in place of the uuid")
 	cmd.Flags().String("dir", "", "dir")
 	cmd.Flags().String("cmd", "", "command")
 	cmd.Flags().StringSlice("argv", nil, "argv")
 	cmd.Flags().StringSlice("env", nil, "env")
 	cmd.Flags().StringSlice("files", nil, "files")
-	cmd.Flags().Bool("restart", false, "restart")
+	cmd.Flags().Bool("restart", false, "restart, same as --restart-policy always")
+	cmd.Flags().String("restart-policy", "", "restart policy: always, on-failure, never or unless-stopped")
+	cmd.Flags().Duration("restart-backoff", 0, "initial delay before a restart, doubled after every failed run (default 1s)")
+	cmd.Flags().Duration("restart-max-backoff", 0, "maximum delay before a restart (default 1m)")
+	cmd.Flags().Float64("restart-jitter", 0, "randomize restart delays by up to this fraction, e.g. 0.2")
+	cmd.Flags().Int("restart-max", 0, "restarts after failed runs allowed within --restart-window before the process is fatal, 0 allows any")
+	cmd.Flags().Duration("restart-window", 0, "window of --restart-max (default 1m)")
+	cmd.Flags().Duration("min-uptime", 0, "a run shorter than this counts as failed")
+	cmd.Flags().String("health-http", "", "health check url, fetched with GET")
+	cmd.Flags().Int("health-status", 0, "expected status of the http health check (default 200)")
+	cmd.Flags().String("health-body", "", "text the body of the http health check must contain")
+	cmd.Flags().String("health-tcp", "", "health check address, connected to over tcp")
+	cmd.Flags().StringSlice("health-exec", nil, "health check command and its arguments, it must exit with 0")
+	cmd.Flags().Duration("health-interval", 0, "health check interval (default 10s)")
+	cmd.Flags().Duration("health-timeout", 0, "health check timeout (default 1s)")
+	cmd.Flags().Int("health-threshold", 0, "failed health checks in a row that restart the process (default 3)")
+	cmd.Flags().Duration("health-start-period", 0, "failed health checks after a start that do not count")
+	cmd.Flags().String("kill-mode", "", "signal the main process only (process), its whole process group (group) or the main process and, with SIGKILL, the group (mixed) (default group)")
+	cmd.Flags().Bool("session", false, "start the process in a session of its own instead of a process group")
+	cmd.Flags().String("user", "", "run the process as this user, a name or uid, with its home, primary group and supplementary groups")
+	cmd.Flags().String("group", "", "run the process with this primary group, a name or gid")
+	cmd.Flags().StringSlice("groups", nil, "run the process with these supplementary groups, names or gids, instead of those of --user")
+	cmd.Flags().String("memory-max", "", "cgroup memory.max, hard memory limit of the process and its children, e.g. 512MiB")
+	cmd.Flags().String("memory-high", "", "cgroup memory.high, memory use above it is throttled and reclaimed, e.g. 384MiB")
+	cmd.Flags().Float64("cpu-max", 0, "cgroup cpu.max in CPUs, e.g. 1.5")
+	cmd.Flags().Int64("pids-max", 0, "cgroup pids.max, tasks the process and its children may have")
+	cmd.Flags().String("max-memory-restart", "", "restart the process gracefully once its resident memory passes this, e.g. 512MiB")
+	cmd.Flags().Duration("max-uptime", 0, "restart the process gracefully once it has been running this long, e.g. 24h")
+	cmd.Flags().StringArray("rlimit", nil, "resource limit as resource=soft:hard or resource=value, e.g. nofile=65536:65536 or core=unlimited, repeatable")
+	cmd.Flags().StringSlice("depends-on", nil, "processes to wait for before starting, as name[:condition], condition is started, ready or completed-successfully")
+	cmd.Flags().String("ready-tcp", "", "ready once this address accepts tcp connections")
+	cmd.Flags().String("ready-http", "", "ready once this url answers 200")
+	cmd.Flags().String("ready-log", "", "ready once a captured output line matches this regular expression")
+	cmd.Flags().Bool("ready-notify", false, "ready once the process sends READY=1 to the socket in NOTIFY_SOCKET")
+	cmd.Flags().Duration("ready-interval", 0, "interval of the tcp and http readiness probes (default 500ms)")
+	cmd.Flags().Duration("ready-timeout", 0, "timeout of the tcp and http readiness probes (default 1s)")
 	cmd.Flags().String("cron", "", "cron")
+	cmd.Flags().StringToString("labels", nil, "labels, e.g. team=billing,env=prod")
+	cmd.Flags().Bool("capture", false, "capture stdout and stderr through pipes, lines are kept for logs and written to files with timestamps")
+	cmd.Flags().String("rotate-size", "", "rotate captured output files larger than this size, e.g. 100MiB")
+	cmd.Flags().Duration("rotate-interval", 0, "rotate captured output files older than this interval")
+	cmd.Flags().Int("rotate-backups", 0, "rotated output files to keep, 0 keeps all")
+	cmd.Flags().Bool("rotate-compress", false, "gzip rotated output files")
 	cobra.CheckErr(cmd.MarkFlagRequired("dir"))
 	cobra.CheckErr(cmd.MarkFlagRequired("cmd"))
 	cobra.CheckErr(cmd.MarkFlagRequired("files"))
@@ -260,17 +563,7 @@ func newKillCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "kill",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			network, err := cmd.Flags().GetString("network")
-			if err != nil {
-				return err
-			}
-
-			address, err := cmd.Flags().GetString("address")
-			if err != nil {
-				return err
-			}
-
-			uuid, err := cmd.Flags().GetString("uuid")
+			id, selector, err := getTarget(cmd)
 			if err != nil {
 				return err
 			}
@@ -280,25 +573,32 @@ func newKillCommand() *cobra.Command {
 				return err
 			}
 
-			conn, err := net.Dial(network, address)
+			client, err := dial(cmd)
 			if err != nil {
 				return err
 			}
 
 			argv := &process.KillArgv{
-				UUID:  uuid,
-				Prune: prune,
+				ID:       id,
+				Selector: selector,
+				Prune:    prune,
 			}
 
 			reply := &process.KillReply{}
 
-			return rpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn)).Call("RPC.Kill", argv, reply)
+			err = client.Call("RPC.Kill", argv, reply)
+			if err != nil {
+				return err
+			}
+
+			return renderResults(reply.Results)
 		},
 	}
 
 	cmd.Flags().String("uuid", "", "uuid")
+	cmd.Flags().String("name", "", "name")
+	cmd.Flags().StringP("selector", "l", "", "label selector, e.g. env=prod,tier!=batch")
 	cmd.Flags().Bool("prune", false, "prune")
-	cobra.CheckErr(cmd.MarkFlagRequired("uuid"))
 
 	return cmd
 }
@@ -307,17 +607,7 @@ func newStopCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "stop",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			network, err := cmd.Flags().GetString("network")
-			if err != nil {
-				return err
-			}
-
-			address, err := cmd.Flags().GetString("address")
-			if err != nil {
-				return err
-			}
-
-			uuid, err := cmd.Flags().GetString("uuid")
+			id, selector, err := getTarget(cmd)
 			if err != nil {
 				return err
 			}
@@ -332,27 +622,34 @@ func newStopCommand() *cobra.Command {
 				return err
 			}
 
-			conn, err := net.Dial(network, address)
+			client, err := dial(cmd)
 			if err != nil {
 				return err
 			}
 
 			argv := &process.StopArgv{
-				UUID:       uuid,
+				ID:         id,
+				Selector:   selector,
 				Gracefully: gracefully,
 				Prune:      prune,
 			}
 
 			reply := &process.StopReply{}
 
-			return rpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn)).Call("RPC.Stop", argv, reply)
+			err = client.Call("RPC.Stop", argv, reply)
+			if err != nil {
+				return err
+			}
+
+			return renderResults(reply.Results)
 		},
 	}
 
 	cmd.Flags().String("uuid", "", "uuid")
+	cmd.Flags().String("name", "", "name")
+	cmd.Flags().StringP("selector", "l", "", "label selector, e.g. env=prod,tier!=batch")
 	cmd.Flags().Duration("gracefully", time.Second*5, "gracefully")
 	cmd.Flags().Bool("prune", false, "prune")
-	cobra.CheckErr(cmd.MarkFlagRequired("uuid"))
 
 	return cmd
 }
@@ -361,45 +658,49 @@ func newRestartCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "restart",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			network, err := cmd.Flags().GetString("network")
-			if err != nil {
-				return err
-			}
-
-			address, err := cmd.Flags().GetString("address")
+			id, selector, err := getTarget(cmd)
 			if err != nil {
 				return err
 			}
 
-			uuid, err := cmd.Flags().GetString("uuid")
+			gracefully, err := cmd.Flags().GetDuration("gracefully")
 			if err != nil {
 				return err
 			}
 
-			gracefully, err := cmd.Flags().GetDuration("gracefully")
+			cascade, err := cmd.Flags().GetBool("cascade")
 			if err != nil {
 				return err
 			}
 
-			conn, err := net.Dial(network, address)
+			client, err := dial(cmd)
 			if err != nil {
 				return err
 			}
 
 			argv := &process.RestartArgv{
-				UUID:       uuid,
+				ID:         id,
+				Selector:   selector,
 				Gracefully: gracefully,
+				Cascade:    cascade,
 			}
 
 			reply := &process.RestartReply{}
 
-			return rpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn)).Call("RPC.Restart", argv, reply)
+			err = client.Call("RPC.Restart", argv, reply)
+			if err != nil {
+				return err
+			}
+
+			return renderResults(reply.Results)
 		},
 	}
 
 	cmd.Flags().String("uuid", "", "uuid")
+	cmd.Flags().String("name", "", "name")
+	cmd.Flags().StringP("selector", "l", "", "label selector, e.g. env=prod,tier!=batch")
 	cmd.Flags().Duration("gracefully", time.Second*5, "gracefully")
-	cobra.CheckErr(cmd.MarkFlagRequired("uuid"))
+	cmd.Flags().Bool("cascade", false, "restart the processes depending on it as well")
 
 	return cmd
 }
@@ -408,24 +709,15 @@ func newSignalCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "signal",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			network, err := cmd.Flags().GetString("network")
-			if err != nil {
-				return err
-			}
-
-			address, err := cmd.Flags().GetString("address")
-			if err != nil {
-				return err
-			}
-
-			uuid, err := cmd.Flags().GetString("uuid")
+			id, selector, err := getTarget(cmd)
 			if err != nil {
 				return err
 			}
 
 			argv := &process.SignalArgv{
-				UUID:   uuid,
-				Signal: syscall.Signal(0),
+				ID:       id,
+				Selector: selector,
+				Signal:   syscall.Signal(0),
 			}
 
 			signal, err := cmd.Flags().GetString("signal")
@@ -500,20 +792,990 @@ func newSignalCommand() *cobra.Command {
 				argv.Signal = syscall.SIGXFSZ
 			}
 
-			conn, err := net.Dial(network, address)
+			client, err := dial(cmd)
 			if err != nil {
 				return err
 			}
 
 			reply := &process.SignalReply{}
 
-			return rpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn)).Call("RPC.Signal", argv, reply)
+			err = client.Call("RPC.Signal", argv, reply)
+			if err != nil {
+				return err
+			}
+
+			return renderResults(reply.Results)
 		},
 	}
 
 	cmd.Flags().String("uuid", "", "uuid")
+	cmd.Flags().String("name", "", "name")
+	cmd.Flags().StringP("selector", "l", "", "label selector, e.g. env=prod,tier!=batch")
 	cmd.Flags().String("signal", "", "signal")
-	cobra.CheckErr(cmd.MarkFlagRequired("uuid"))
 
 	return cmd
 }
+
+func getRotation(cmd *cobra.Command, prefix string) (*process.Rotation, error) {
+	size, err := cmd.Flags().GetString(prefix + "rotate-size")
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := cmd.Flags().GetDuration(prefix + "rotate-interval")
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := cmd.Flags().GetInt(prefix + "rotate-backups")
+	if err != nil {
+		return nil, err
+	}
+
+	compress, err := cmd.Flags().GetBool(prefix + "rotate-compress")
+	if err != nil {
+		return nil, err
+	}
+
+	if size == "" && interval == 0 && backups == 0 && !compress {
+		return nil, nil
+	}
+
+	rotation := &process.Rotation{
+		Interval:   interval,
+		MaxBackups: backups,
+		Compress:   compress,
+	}
+
+	if size != "" {
+		rotation.MaxSize, err = process.ParseSize(size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rotation, nil
+}
+
+func getCgroup(cmd *cobra.Command) (*process.Cgroup, error) {
+	memoryMax, err := cmd.Flags().GetString("memory-max")
+	if err != nil {
+		return nil, err
+	}
+
+	memoryHigh, err := cmd.Flags().GetString("memory-high")
+	if err != nil {
+		return nil, err
+	}
+
+	cpuMax, err := cmd.Flags().GetFloat64("cpu-max")
+	if err != nil {
+		return nil, err
+	}
+
+	pidsMax, err := cmd.Flags().GetInt64("pids-max")
+	if err != nil {
+		return nil, err
+	}
+
+	if memoryMax == "" && memoryHigh == "" && cpuMax == 0 && pidsMax == 0 {
+		return nil, nil
+	}
+
+	cgroup := &process.Cgroup{
+		CPUMax:  cpuMax,
+		PidsMax: pidsMax,
+	}
+
+	if memoryMax != "" {
+		cgroup.MemoryMax, err = process.ParseSize(memoryMax)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if memoryHigh != "" {
+		cgroup.MemoryHigh, err = process.ParseSize(memoryHigh)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cgroup, nil
+}
+
+func getRestartPolicy(cmd *cobra.Command) (*process.RestartPolicy, error) {
+	policy, err := cmd.Flags().GetString("restart-policy")
+	if err != nil {
+		return nil, err
+	}
+
+	backoff, err := cmd.Flags().GetDuration("restart-backoff")
+	if err != nil {
+		return nil, err
+	}
+
+	maxBackoff, err := cmd.Flags().GetDuration("restart-max-backoff")
+	if err != nil {
+		return nil, err
+	}
+
+	jitter, err := cmd.Flags().GetFloat64("restart-jitter")
+	if err != nil {
+		return nil, err
+	}
+
+	max, err := cmd.Flags().GetInt("restart-max")
+	if err != nil {
+		return nil, err
+	}
+
+	window, err := cmd.Flags().GetDuration("restart-window")
+	if err != nil {
+		return nil, err
+	}
+
+	minUptime, err := cmd.Flags().GetDuration("min-uptime")
+	if err != nil {
+		return nil, err
+	}
+
+	if policy == "" && backoff == 0 && maxBackoff == 0 && jitter == 0 && max == 0 && window == 0 && minUptime == 0 {
+		return nil, nil
+	}
+
+	restart, err := cmd.Flags().GetBool("restart")
+	if err != nil {
+		return nil, err
+	}
+
+	if policy == "" && !restart {
+		policy = process.RestartNever
+	}
+
+	return &process.RestartPolicy{
+		Policy:         policy,
+		InitialBackoff: backoff,
+		MaxBackoff:     maxBackoff,
+		Jitter:         jitter,
+		MaxRestarts:    max,
+		Window:         window,
+		MinUptime:      minUptime,
+	}, nil
+}
+
+func getHealthCheck(cmd *cobra.Command) (*process.HealthCheck, error) {
+	url, err := cmd.Flags().GetString("health-http")
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := cmd.Flags().GetInt("health-status")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := cmd.Flags().GetString("health-body")
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := cmd.Flags().GetString("health-tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	exec, err := cmd.Flags().GetStringSlice("health-exec")
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := cmd.Flags().GetDuration("health-interval")
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := cmd.Flags().GetDuration("health-timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	threshold, err := cmd.Flags().GetInt("health-threshold")
+	if err != nil {
+		return nil, err
+	}
+
+	startPeriod, err := cmd.Flags().GetDuration("health-start-period")
+	if err != nil {
+		return nil, err
+	}
+
+	check := &process.HealthCheck{
+		URL:          url,
+		ExpectStatus: status,
+		ExpectBody:   body,
+		Address:      address,
+		Exec:         exec,
+		Interval:     interval,
+		Timeout:      timeout,
+		Threshold:    threshold,
+		StartPeriod:  startPeriod,
+	}
+
+	var types []string
+	if url != "" {
+		types = append(types, process.HealthHTTP)
+	}
+	if address != "" {
+		types = append(types, process.HealthTCP)
+	}
+	if len(exec) > 0 {
+		types = append(types, process.HealthExec)
+	}
+
+	switch len(types) {
+	case 0:
+		return nil, nil
+	case 1:
+		check.Type = types[0]
+		return check, nil
+	default:
+		return nil, errors.New("only one of --health-http, --health-tcp and --health-exec allowed")
+	}
+}
+
+func getReadiness(cmd *cobra.Command) (*process.Readiness, error) {
+	address, err := cmd.Flags().GetString("ready-tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := cmd.Flags().GetString("ready-http")
+	if err != nil {
+		return nil, err
+	}
+
+	pattern, err := cmd.Flags().GetString("ready-log")
+	if err != nil {
+		return nil, err
+	}
+
+	notify, err := cmd.Flags().GetBool("ready-notify")
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := cmd.Flags().GetDuration("ready-interval")
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := cmd.Flags().GetDuration("ready-timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	readiness := &process.Readiness{
+		Address:  address,
+		URL:      url,
+		Pattern:  pattern,
+		Interval: interval,
+		Timeout:  timeout,
+	}
+
+	var types []string
+	if address != "" {
+		types = append(types, process.ReadyTCP)
+	}
+	if url != "" {
+		types = append(types, process.ReadyHTTP)
+	}
+	if pattern != "" {
+		types = append(types, process.ReadyLog)
+	}
+	if notify {
+		types = append(types, process.ReadyNotify)
+	}
+
+	switch len(types) {
+	case 0:
+		return nil, nil
+	case 1:
+		readiness.Type = types[0]
+		return readiness, nil
+	default:
+		return nil, errors.New("only one of --ready-tcp, --ready-http, --ready-log and --ready-notify allowed")
+	}
+}
+
+func getDependsOn(cmd *cobra.Command) ([]*process.Dependency, error) {
+	values, err := cmd.Flags().GetStringSlice("depends-on")
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies := make([]*process.Dependency, 0, len(values))
+	for _, value := range values {
+		dependency, err := process.ParseDependency(value)
+		if err != nil {
+			return nil, err
+		}
+
+		dependencies = append(dependencies, dependency)
+	}
+
+	return dependencies, nil
+}
+
+func getRlimits(cmd *cobra.Command) ([]*process.Rlimit, error) {
+	values, err := cmd.Flags().GetStringArray("rlimit")
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make([]*process.Rlimit, 0, len(values))
+	for _, value := range values {
+		limit, err := process.ParseRlimit(value)
+		if err != nil {
+			return nil, err
+		}
+
+		limits = append(limits, limit)
+	}
+
+	return limits, nil
+}
+
+func getID(cmd *cobra.Command) (string, error) {
+	uuid, err := cmd.Flags().GetString("uuid")
+	if err != nil {
+		return "", err
+	}
+
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case uuid != "" && name != "":
+		return "", errors.New("only one of --uuid and --name allowed")
+	case uuid != "":
+		return uuid, nil
+	case name != "":
+		return name, nil
+	default:
+		return "", errors.New("--uuid or --name required")
+	}
+}
+
+func getTarget(cmd *cobra.Command) (string, string, error) {
+	selector, err := cmd.Flags().GetString("selector")
+	if err != nil {
+		return "", "", err
+	}
+
+	if selector == "" {
+		id, err := getID(cmd)
+		return id, "", err
+	}
+
+	if cmd.Flags().Changed("uuid") || cmd.Flags().Changed("name") {
+		return "", "", errors.New("only one of --uuid, --name and --selector allowed")
+	}
+
+	return "", selector, nil
+}
+
+// getAuth returns the authentication given by the --tls-* and --token flags,
+// nil when none of them is set. The token falls back to $PROCESS_TOKEN, which
+// keeps it out of the process list.
+func getAuth(cmd *cobra.Command, server bool) (*process.Auth, error) {
+	cert, err := cmd.Flags().GetString("tls-cert")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := cmd.Flags().GetString("tls-key")
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := cmd.Flags().GetString("tls-ca")
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cmd.Flags().GetString("token")
+	if err != nil {
+		return nil, err
+	}
+
+	if token == "" {
+		token = os.Getenv("PROCESS_TOKEN")
+	}
+
+	if cert == "" && key == "" && ca == "" && token == "" {
+		return nil, nil
+	}
+
+	auth := &process.Auth{Token: token}
+
+	switch {
+	case server && (cert != "" || key != ""):
+		auth.TLS, err = process.ServerTLS(cert, key, ca)
+	case server && ca != "":
+		err = errors.New("--tls-ca requires --tls-cert and --tls-key")
+	case !server && (cert != "" || key != "" || ca != ""):
+		auth.TLS, err = process.ClientTLS(cert, key, ca)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+func getAddress(cmd *cobra.Command) (string, string, error) {
+	network, err := cmd.Flags().GetString("network")
+	if err != nil {
+		return "", "", err
+	}
+
+	address, err := cmd.Flags().GetString("address")
+	if err != nil {
+		return "", "", err
+	}
+
+	if network == "unix" && !cmd.Flags().Changed("address") {
+		address, err = process.DefaultSocket()
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return network, address, nil
+}
+
+func getPeerRules(cmd *cobra.Command) ([]*process.PeerRule, error) {
+	allow, err := cmd.Flags().GetStringArray("socket-allow")
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*process.PeerRule, 0, len(allow))
+	for _, s := range allow {
+		rule, err := process.ParsePeerRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// dial connects to the service given by --network and --address, the default
+// socket only when it belongs to root or the current user.
+func dial(cmd *cobra.Command) (*rpc.Client, error) {
+	network, address, err := getAddress(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" && !cmd.Flags().Changed("address") {
+		err = process.CheckSocketOwner(address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	auth, err := getAuth(cmd, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return process.Dial(network, address, auth)
+}
+
+func renderResults(results []*process.Result) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	failed := 0
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"UUID", "Name", "Pid", "Error"})
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+		table.Append([]string{result.UUID, result.Name, fmt.Sprint(result.Pid), result.Error})
+	}
+
+	table.Render()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d operations failed", failed, len(results))
+	}
+
+	return nil
+}
+
+func newEcosystemCommand(use string, delete, dryRun bool) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: use,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := cmd.Flags().GetString("file")
+			if err != nil {
+				return err
+			}
+
+			gracefully, err := cmd.Flags().GetDuration("gracefully")
+			if err != nil {
+				return err
+			}
+
+			force, err := cmd.Flags().GetBool("force")
+			if err != nil {
+				return err
+			}
+
+			ecosystem, err := process.LoadEcosystem(file)
+			if err != nil {
+				return err
+			}
+
+			source, err := filepath.Abs(file)
+			if err != nil {
+				return err
+			}
+
+			client, err := dial(cmd)
+			if err != nil {
+				return err
+			}
+
+			argv := &process.ApplyArgv{
+				Source:     source,
+				Processes:  ecosystem.Processes,
+				Delete:     delete,
+				DryRun:     dryRun,
+				Force:      force,
+				Gracefully: gracefully,
+			}
+
+			reply := &process.ApplyReply{}
+
+			err = client.Call("RPC.Apply", argv, reply)
+			if err != nil {
+				return err
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Name", "Action", "UUID", "Diff"})
+			for _, change := range reply.Changes {
+				table.Append([]string{change.Name, change.Action, change.UUID, strings.Join(change.Diff, "\n")})
+			}
+
+			table.Render()
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("file", "f", "", "ecosystem file, yaml, toml or json")
+	cmd.Flags().Duration("gracefully", time.Second*5, "gracefully")
+	cmd.Flags().Bool("force", false, "take over processes of the same name started by start or by another ecosystem file")
+	cobra.CheckErr(cmd.MarkFlagRequired("file"))
+
+	return cmd
+}
+
+func newLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "logs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := getID(cmd)
+			if err != nil {
+				return err
+			}
+
+			follow, err := cmd.Flags().GetBool("follow")
+			if err != nil {
+				return err
+			}
+
+			lines, err := cmd.Flags().GetInt("lines")
+			if err != nil {
+				return err
+			}
+
+			stdout, err := cmd.Flags().GetBool("stdout")
+			if err != nil {
+				return err
+			}
+
+			stderr, err := cmd.Flags().GetBool("stderr")
+			if err != nil {
+				return err
+			}
+
+			client, err := dial(cmd)
+			if err != nil {
+				return err
+			}
+
+			defer client.Close()
+
+			argv := &process.LogsArgv{
+				ID:     id,
+				Lines:  lines,
+				Stdout: stdout,
+				Stderr: stderr,
+			}
+
+			for {
+				reply := &process.LogsReply{}
+
+				err = client.Call("RPC.Logs", argv, reply)
+				if err != nil {
+					return err
+				}
+
+				for _, line := range reply.Lines {
+					fmt.Printf("%s %s %s\n", line.Time.Format(time.RFC3339), line.Stream, line.Text)
+				}
+
+				if !follow {
+					return nil
+				}
+
+				argv.Cursor = reply.Cursor
+				argv.Wait = time.Second * 30
+			}
+		},
+	}
+
+	cmd.Flags().String("uuid", "", "uuid")
+	cmd.Flags().String("name", "", "name")
+	cmd.Flags().BoolP("follow", "f", false, "follow")
+	cmd.Flags().IntP("lines", "n", 100, "lines")
+	cmd.Flags().Bool("stdout", false, "stdout only")
+	cmd.Flags().Bool("stderr", false, "stderr only")
+
+	cmd.AddCommand(newLogsRotateCommand())
+
+	return cmd
+}
+
+func newLogsRotateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "rotate",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := getID(cmd)
+			if err != nil {
+				return err
+			}
+
+			client, err := dial(cmd)
+			if err != nil {
+				return err
+			}
+
+			argv := &process.RotateArgv{
+				ID: id,
+			}
+
+			reply := &process.RotateReply{}
+
+			return client.Call("RPC.Rotate", argv, reply)
+		},
+	}
+
+	cmd.Flags().String("uuid", "", "uuid")
+	cmd.Flags().String("name", "", "name")
+
+	return cmd
+}
+
+func newWaitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "wait",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := getID(cmd)
+			if err != nil {
+				return err
+			}
+
+			condition, err := cmd.Flags().GetString("for")
+			if err != nil {
+				return err
+			}
+
+			timeout, err := cmd.Flags().GetDuration("timeout")
+			if err != nil {
+				return err
+			}
+
+			client, err := dial(cmd)
+			if err != nil {
+				return err
+			}
+
+			argv := &process.WaitArgv{
+				ID:      id,
+				For:     condition,
+				Timeout: timeout,
+			}
+
+			reply := &process.WaitReply{}
+
+			err = client.Call("RPC.Wait", argv, reply)
+			if err != nil {
+				return err
+			}
+
+			return renderResults([]*process.Result{{UUID: reply.UUID, Name: reply.Name, Pid: reply.Pid}})
+		},
+	}
+
+	cmd.Flags().String("uuid", "", "uuid")
+	cmd.Flags().String("name", "", "name")
+	cmd.Flags().String("for", process.WaitReady, "condition: ready, running or exited")
+	cmd.Flags().Duration("timeout", time.Second*30, "timeout, the command fails once it passed")
+
+	return cmd
+}
+
+func newAuditCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "audit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			since, err := getTime(cmd, "since")
+			if err != nil {
+				return err
+			}
+
+			until, err := getTime(cmd, "until")
+			if err != nil {
+				return err
+			}
+
+			name, err := cmd.Flags().GetString("process")
+			if err != nil {
+				return err
+			}
+
+			operation, err := cmd.Flags().GetString("operation")
+			if err != nil {
+				return err
+			}
+
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return err
+			}
+
+			client, err := dial(cmd)
+			if err != nil {
+				return err
+			}
+
+			argv := &process.AuditArgv{
+				Since:     since,
+				Until:     until,
+				Process:   name,
+				Operation: operation,
+				Limit:     limit,
+			}
+
+			reply := &process.AuditReply{}
+
+			err = client.Call("RPC.Audit", argv, reply)
+			if err != nil {
+				return err
+			}
+
+			if len(reply.Entries) == 0 {
+				return nil
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Time", "Peer", "Operation", "Target", "Processes", "Outcome", "Error"})
+			for _, entry := range reply.Entries {
+				table.Append([]string{
+					entry.Time.Local().Format(time.RFC3339),
+					entry.Peer,
+					entry.Operation,
+					entry.Target,
+					strings.Join(entry.Processes, "\n"),
+					entry.Outcome,
+					entry.Error,
+				})
+			}
+
+			table.Render()
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("since", "", "entries from this time on, RFC 3339 or a duration back from now, e.g. 1h")
+	cmd.Flags().String("until", "", "entries up to this time, RFC 3339 or a duration back from now")
+	cmd.Flags().String("process", "", "entries naming or acting on this process, name or uuid")
+	cmd.Flags().String("operation", "", "entries of this operation, e.g. stop")
+	cmd.Flags().Int("limit", 100, "latest entries shown, 0 shows all")
+
+	return cmd
+}
+
+// getTime returns the time given by the flag, as RFC 3339 or a duration back
+// from now, zero when not set.
+func getTime(cmd *cobra.Command, flag string) (time.Time, error) {
+	s, err := cmd.Flags().GetString(flag)
+	if err != nil || s == "" {
+		return time.Time{}, err
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s: %s, want RFC 3339 or a duration", flag, s)
+	}
+
+	return t, nil
+}
+
+var topColumns = []string{"Name", "UUID", "Pid", "Status", "CPU", "RSS", "Threads", "FDs", "ReadBytes", "WriteBytes", "Uptime"}
+
+func newTopCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "top",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			selector, err := cmd.Flags().GetString("selector")
+			if err != nil {
+				return err
+			}
+
+			by, err := cmd.Flags().GetString("sort")
+			if err != nil {
+				return err
+			}
+
+			interval, err := cmd.Flags().GetDuration("interval")
+			if err != nil {
+				return err
+			}
+
+			iterations, err := cmd.Flags().GetInt("iterations")
+			if err != nil {
+				return err
+			}
+
+			column := ""
+			for _, c := range topColumns {
+				if strings.EqualFold(c, by) {
+					column = c
+				}
+			}
+			if column == "" {
+				return fmt.Errorf("unknown sort column: %s", by)
+			}
+
+			client, err := dial(cmd)
+			if err != nil {
+				return err
+			}
+
+			defer client.Close()
+
+			// only clear the screen of a terminal, piped output keeps every
+			// refresh.
+			clear := false
+			if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+				clear = true
+			}
+
+			for i := 0; iterations <= 0 || i < iterations; i++ {
+				if i > 0 {
+					time.Sleep(interval)
+				}
+
+				reply := &process.ListReply{}
+
+				err = client.Call("RPC.List", &process.ListArgv{Selector: selector, Status: "running"}, reply)
+				if err != nil {
+					return err
+				}
+
+				sortTop(reply.Metadata, column)
+
+				if clear {
+					fmt.Print("\033[H\033[2J")
+				}
+
+				fmt.Println(time.Now().Format(time.RFC3339))
+
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetHeader(topColumns)
+				for _, meta := range reply.Metadata {
+					values := make([]string, 0, len(topColumns))
+					for _, c := range topColumns {
+						values = append(values, meta[c])
+					}
+					table.Append(values)
+				}
+				table.Render()
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP("selector", "l", "", "label selector, e.g. env=prod,tier!=batch")
+	cmd.Flags().String("sort", "CPU", "sort column: "+strings.Join(topColumns, ", ")+", numbers sort largest first")
+	cmd.Flags().Duration("interval", time.Second*2, "refresh interval")
+	cmd.Flags().Int("iterations", 0, "refreshes before exiting, 0 refreshes until interrupted")
+
+	return cmd
+}
+
+// sortTop sorts the rows by a column, numbers, sizes and durations largest
+// first and anything else in ascending order.
+func sortTop(rows []map[string]string, column string) {
+	value := func(s string) (float64, bool) {
+		if s == "" {
+			return 0, true
+		}
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n, true
+		}
+		if d, err := time.ParseDuration(s); err == nil {
+			return float64(d), true
+		}
+		if size, err := process.ParseSize(s); err == nil {
+			return float64(size), true
+		}
+		return 0, false
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i][column], rows[j][column]
+
+		x, ok := value(a)
+		y, ok2 := value(b)
+		if ok && ok2 && column != "Name" && column != "UUID" {
+			if x != y {
+				return x > y
+			}
+			return rows[i]["Name"] < rows[j]["Name"]
+		}
+
+		return a < b
+	})
+}
@@ -0,0 +1,173 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type identity struct {
+	user       *user.User
+	credential *syscall.Credential
+}
+
+// identity resolves the user, group and supplementary groups, names or ids,
+// nil when none is set. Without groups the user keeps its own supplementary
+// groups, as after initgroups(3).
+func (a *Attributes) identity() (*identity, error) {
+	if a.user == "" && a.group == "" && len(a.groups) == 0 {
+		return nil, nil
+	}
+
+	id := &identity{
+		credential: &syscall.Credential{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+		},
+	}
+
+	if a.user != "" {
+		u, err := lookupUser(a.user)
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid of user %s: %s", a.user, u.Uid)
+		}
+
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid of user %s: %s", a.user, u.Gid)
+		}
+
+		id.credential.Uid = uint32(uid)
+		id.credential.Gid = uint32(gid)
+
+		if u.Username != "" {
+			id.user = u
+		}
+
+		if len(a.groups) == 0 && id.user != nil {
+			gids, err := u.GroupIds()
+			if err != nil {
+				return nil, fmt.Errorf("lookup groups of user %s: %w", a.user, err)
+			}
+
+			for _, g := range gids {
+				gid, err := strconv.ParseUint(g, 10, 32)
+				if err == nil {
+					id.credential.Groups = append(id.credential.Groups, uint32(gid))
+				}
+			}
+		}
+	}
+
+	if a.group != "" {
+		gid, err := lookupGroup(a.group)
+		if err != nil {
+			return nil, err
+		}
+
+		id.credential.Gid = gid
+	}
+
+	for _, group := range a.groups {
+		gid, err := lookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+
+		id.credential.Groups = append(id.credential.Groups, gid)
+	}
+
+	return id, nil
+}
+
+// env sets HOME, USER and LOGNAME of the user, unless env sets them itself.
+// A nil env stands for the environment of the manager, which are replaced.
+func (id *identity) env(env []string) []string {
+	if id == nil || id.user == nil {
+		return env
+	}
+
+	explicit := make(map[string]bool)
+	for _, kv := range env {
+		explicit[strings.SplitN(kv, "=", 2)[0]] = true
+	}
+
+	for _, kv := range [][2]string{
+		{"HOME", id.user.HomeDir},
+		{"USER", id.user.Username},
+		{"LOGNAME", id.user.Username},
+	} {
+		if !explicit[kv[0]] {
+			env = setEnv(env, kv[0], kv[1])
+		}
+	}
+
+	return env
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		u, err := user.LookupId(name)
+		if err == nil {
+			return u, nil
+		}
+
+		// a uid without a passwd entry, common in containers, has no name,
+		// home or groups, its group is the same id.
+		var unknown user.UnknownUserIdError
+		if errors.As(err, &unknown) {
+			return &user.User{Uid: name, Gid: name}, nil
+		}
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup user %s: %w", name, err)
+	}
+
+	return u, nil
+}
+
+func lookupGroup(name string) (uint32, error) {
+	if gid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("lookup group %s: %w", name, err)
+	}
+
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gid of group %s: %s", name, g.Gid)
+	}
+
+	return uint32(gid), nil
+}
+
+// setEnv returns env with key set to value, a nil env stands for the
+// environment of the manager.
+func setEnv(env []string, key, value string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+
+	e := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			e = append(e, kv)
+		}
+	}
+
+	return append(e, key+"="+value)
+}
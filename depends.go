@@ -0,0 +1,416 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DependStarted   = "started"
+	DependReady     = "ready"
+	DependCompleted = "completed-successfully"
+)
+
+// Dependency names a process that must meet Condition, started by default,
+// before the dependent process starts.
+type Dependency struct {
+	Name      string `json:"name" yaml:"name" toml:"name"`
+	Condition string `json:"condition" yaml:"condition" toml:"condition"`
+}
+
+func (d *Dependency) String() string {
+	return d.Name + ":" + d.condition()
+}
+
+func (d *Dependency) condition() string {
+	if d.Condition == "" {
+		return DependStarted
+	}
+	return d.Condition
+}
+
+// ParseDependency parses name or name:condition.
+func ParseDependency(s string) (*Dependency, error) {
+	name, condition := s, ""
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		name, condition = s[:i], s[i+1:]
+	}
+
+	d := &Dependency{Name: name, Condition: condition}
+
+	return d, d.validate()
+}
+
+func (d *Dependency) validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("dependency name required")
+	}
+
+	switch d.Condition {
+	case "", DependStarted, DependReady, DependCompleted:
+		return nil
+	}
+
+	return fmt.Errorf("invalid dependency condition: %s", d.Condition)
+}
+
+func formatDependencies(dependencies []*Dependency) []string {
+	s := make([]string, 0, len(dependencies))
+	for _, d := range dependencies {
+		s = append(s, d.String())
+	}
+	return s
+}
+
+// validateDependencies rejects a dependency of the named process on itself
+// and dependencies that close a cycle through graph, which maps the names of
+// the other processes to their dependencies.
+func validateDependencies(name string, dependencies []*Dependency, graph map[string][]*Dependency) error {
+	for _, d := range dependencies {
+		err := d.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	g := make(map[string][]*Dependency, len(graph)+1)
+	for n, deps := range graph {
+		g[n] = deps
+	}
+	g[name] = dependencies
+
+	visited := make(map[string]bool)
+
+	var visit func(n string, path []string) error
+	visit = func(n string, path []string) error {
+		for i, p := range path {
+			if p == n {
+				return fmt.Errorf("dependency cycle: %s", strings.Join(append(path[i:], n), " -> "))
+			}
+		}
+		if visited[n] {
+			return nil
+		}
+		visited[n] = true
+
+		for _, d := range g[n] {
+			err := visit(d.Name, append(path, n))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return visit(name, nil)
+}
+
+// dependencyGraph maps the names of the processes to their dependencies.
+// Called with m.lock held.
+func (m *Manager) dependencyGraph() map[string][]*Dependency {
+	graph := make(map[string][]*Dependency, len(m.processes))
+	for _, process := range m.processes {
+		if process.attributes.name != "" {
+			graph[process.attributes.name] = process.attributes.dependsOn
+		}
+	}
+	return graph
+}
+
+// depths returns the length of the longest dependency chain below each named
+// process, processes without dependencies have depth 0.
+func (m *Manager) depths() map[string]int {
+	m.lock.Lock()
+	graph := m.dependencyGraph()
+	m.lock.Unlock()
+
+	depths := make(map[string]int, len(graph))
+
+	var depth func(name string, seen map[string]bool) int
+	depth = func(name string, seen map[string]bool) int {
+		if d, ok := depths[name]; ok {
+			return d
+		}
+		if seen[name] {
+			return 0
+		}
+		seen[name] = true
+
+		d := 0
+		for _, dependency := range graph[name] {
+			if n := depth(dependency.Name, seen) + 1; n > d {
+				d = n
+			}
+		}
+
+		depths[name] = d
+
+		return d
+	}
+
+	for name := range graph {
+		depth(name, make(map[string]bool))
+	}
+
+	return depths
+}
+
+// dependencyOrder sorts names so dependencies come before their dependents,
+// or after them when reverse is set, as for a group stop.
+func (m *Manager) dependencyOrder(names []string, reverse bool) {
+	depths := m.depths()
+
+	sort.SliceStable(names, func(i, j int) bool {
+		if reverse {
+			return depths[names[i]] > depths[names[j]]
+		}
+		return depths[names[i]] < depths[names[j]]
+	})
+}
+
+// dependents returns the processes depending on process, directly or not,
+// dependencies first.
+func (m *Manager) dependents(process *Process) []*Process {
+	m.lock.Lock()
+	graph := m.dependencyGraph()
+	m.lock.Unlock()
+
+	found := make(map[string]bool)
+
+	var walk func(name string)
+	walk = func(name string) {
+		for n, deps := range graph {
+			for _, d := range deps {
+				if d.Name == name && !found[n] {
+					found[n] = true
+					walk(n)
+				}
+			}
+		}
+	}
+
+	walk(process.attributes.name)
+
+	names := make([]string, 0, len(found))
+	for n := range found {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+	m.dependencyOrder(names, false)
+
+	processes := make([]*Process, 0, len(names))
+	for _, n := range names {
+		if p := m.searchName(n); p != nil {
+			processes = append(processes, p)
+		}
+	}
+
+	return processes
+}
+
+// met reports whether the process meets the dependency condition. Called with
+// p.m held.
+func (p *Process) met(condition string) bool {
+	switch condition {
+	case DependReady:
+		return p.status == statusRunning && p.ready
+	case DependCompleted:
+		return (p.status == statusExited || p.status == statusScheduled) && p.processState != nil && p.processState.Success()
+	}
+
+	return p.status == statusRunning
+}
+
+func (m *Manager) unmet(process *Process) []*Dependency {
+	process.m.Lock()
+	dependencies := process.attributes.dependsOn
+	process.m.Unlock()
+
+	var unmet []*Dependency
+	for _, d := range dependencies {
+		dependency := m.searchName(d.Name)
+		if dependency == nil {
+			unmet = append(unmet, d)
+			continue
+		}
+
+		dependency.m.Lock()
+		ok := dependency.met(d.condition())
+		dependency.m.Unlock()
+
+		if !ok {
+			unmet = append(unmet, d)
+		}
+	}
+
+	return unmet
+}
+
+// deferStart puts the process in waiting until its dependencies are met, then
+// queues its start.
+func (m *Manager) deferStart(process *Process, unmet []*Dependency) {
+	process.m.Lock()
+	defer process.m.Unlock()
+
+	process.cancelPending()
+
+	from := process.status
+	if process.transition(statusWaiting) != nil {
+		return
+	}
+
+	pending := make(chan struct{})
+	process.pending = pending
+	process.waitingFrom = from
+
+	process.pushEvent(eventKindData, fmt.Sprintf("process: %s, waiting for %s", process.uuid, strings.Join(formatDependencies(unmet), ", ")))
+
+	go m.awaitDependencies(process, pending)
+}
+
+func (m *Manager) awaitDependencies(process *Process, pending chan struct{}) {
+	for {
+		change := m.changes()
+
+		if len(m.unmet(process)) == 0 {
+			break
+		}
+
+		select {
+		case <-pending:
+			return
+		case <-change:
+		}
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), operateTimeout)
+		_, err := m.Operate(ctx, &operateLaunch{id: process.uuid, pending: pending})
+		cancel()
+
+		if err == nil {
+			return
+		}
+
+		process.m.Lock()
+		process.pushEvent(eventKindErr, fmt.Sprintf("process: %s, start after dependencies failed: %s", process.uuid, err))
+		process.m.Unlock()
+
+		select {
+		case <-pending:
+			return
+		case <-m.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// launch starts a waiting process once its dependencies are met, unless the
+// wait was cancelled in the meantime.
+func (m *Manager) launch(process *Process, pending chan struct{}) error {
+	process.m.Lock()
+	current := process.pending == pending
+	if current {
+		process.pending = nil
+	}
+	process.m.Unlock()
+
+	if !current {
+		return nil
+	}
+
+	unmet := m.unmet(process)
+	if len(unmet) > 0 {
+		process.m.Lock()
+		_ = process.transition(process.waitingFrom)
+		process.m.Unlock()
+
+		m.deferStart(process, unmet)
+
+		return nil
+	}
+
+	err := process.start()
+
+	m.persist()
+
+	return err
+}
+
+// cancelPending gives up waiting for the dependencies, the process falls back
+// to the state it waited from. Called with p.m held.
+func (p *Process) cancelPending() {
+	if p.pending == nil {
+		return
+	}
+
+	close(p.pending)
+	p.pending = nil
+
+	if p.status == statusWaiting {
+		_ = p.transition(p.waitingFrom)
+	}
+}
+
+func (m *Manager) changes() <-chan struct{} {
+	m.changeLock.Lock()
+	defer m.changeLock.Unlock()
+
+	return m.change
+}
+
+// changed wakes up everyone waiting for a process to change.
+func (m *Manager) changed() {
+	m.changeLock.Lock()
+	defer m.changeLock.Unlock()
+
+	close(m.change)
+	m.change = make(chan struct{})
+}
+
+// cascadeRestart restarts the process and every process depending on it,
+// dependents are stopped first and started again once their dependencies
+// are met.
+func (m *Manager) cascadeRestart(ctx context.Context, process *Process, gracefully time.Duration) error {
+	var dependents []*Process
+	err := m.step(func() error {
+		dependents = m.dependents(process)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dependents) - 1; i >= 0; i-- {
+		err := m.halt(ctx, dependents[i], syscall.SIGTERM, gracefully, false, m.step)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.restartProcess(ctx, process, gracefully)
+	if err != nil {
+		return err
+	}
+
+	return m.step(func() error {
+		for _, dependent := range dependents {
+			err := m.startProcess(dependent)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
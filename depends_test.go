@@ -0,0 +1,72 @@
+package process
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	graph := map[string][]*Dependency{
+		"web": {{Name: "api", Condition: DependReady}},
+		"api": {{Name: "db"}},
+		"db":  nil,
+	}
+
+	tests := []struct {
+		name         string
+		process      string
+		dependencies []*Dependency
+		err          string
+	}{
+		{"new dependent", "worker", []*Dependency{{Name: "api"}, {Name: "db"}}, ""},
+		{"unknown dependency", "worker", []*Dependency{{Name: "queue"}}, ""},
+		{"unnamed process", "", []*Dependency{{Name: "web"}}, ""},
+		{"update keeps the graph acyclic", "api", []*Dependency{{Name: "db", Condition: DependCompleted}}, ""},
+		{"self", "worker", []*Dependency{{Name: "worker"}}, "dependency cycle: worker -> worker"},
+		{"cycle", "db", []*Dependency{{Name: "web"}}, "dependency cycle: db -> web -> api -> db"},
+		{"invalid condition", "", []*Dependency{{Name: "x", Condition: "up"}}, "invalid dependency condition: up"},
+		{"no name", "worker", []*Dependency{{Condition: DependStarted}}, "dependency name required"},
+	}
+
+	for _, test := range tests {
+		err := validateDependencies(test.process, test.dependencies, graph)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+		}
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	m := NewManager()
+	for name, dependencies := range map[string][]*Dependency{
+		"web":    {{Name: "api"}, {Name: "db"}},
+		"api":    {{Name: "db"}},
+		"db":     nil,
+		"worker": nil,
+	} {
+		m.processes[name] = m.newProcess(name, &Attributes{name: name, dependsOn: dependencies})
+	}
+
+	tests := []struct {
+		names   []string
+		reverse bool
+		want    []string
+	}{
+		{[]string{"web", "worker", "api", "db"}, false, []string{"worker", "db", "api", "web"}},
+		{[]string{"web", "worker", "api", "db"}, true, []string{"web", "api", "worker", "db"}},
+		{[]string{"api", "db"}, false, []string{"db", "api"}},
+		{[]string{"db", "api"}, true, []string{"api", "db"}},
+	}
+
+	for _, test := range tests {
+		names := append([]string(nil), test.names...)
+		m.dependencyOrder(names, test.reverse)
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("dependencyOrder(%v, %v) = %v, want %v", test.names, test.reverse, names, test.want)
+		}
+	}
+}
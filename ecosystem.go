@@ -0,0 +1,153 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Ecosystem declares the processes a manager should run, it is reconciled
+// against the manager with OperateApply.
+type Ecosystem struct {
+	Processes []*EcosystemProcess `json:"processes" yaml:"processes" toml:"processes"`
+}
+
+type EcosystemProcess struct {
+	Name    string   `json:"name" yaml:"name" toml:"name"`
+	Dir     string   `json:"dir" yaml:"dir" toml:"dir"`
+	Cmd     string   `json:"cmd" yaml:"cmd" toml:"cmd"`
+	Argv    []string `json:"argv" yaml:"argv" toml:"argv"`
+	Env     []string `json:"env" yaml:"env" toml:"env"`
+	Files   []string `json:"files" yaml:"files" toml:"files"`
+	Restart bool     `json:"restart" yaml:"restart" toml:"restart"`
+	Cron    string   `json:"cron" yaml:"cron" toml:"cron"`
+
+	Labels   map[string]string `json:"labels" yaml:"labels" toml:"labels"`
+	Capture  bool              `json:"capture" yaml:"capture" toml:"capture"`
+	Rotation *Rotation         `json:"rotation" yaml:"rotation" toml:"rotation"`
+
+	RestartPolicy *RestartPolicy `json:"restart_policy" yaml:"restart_policy" toml:"restart_policy"`
+	HealthCheck   *HealthCheck   `json:"health_check" yaml:"health_check" toml:"health_check"`
+	Readiness     *Readiness     `json:"readiness" yaml:"readiness" toml:"readiness"`
+	DependsOn     []*Dependency  `json:"depends_on" yaml:"depends_on" toml:"depends_on"`
+	KillMode      string         `json:"kill_mode" yaml:"kill_mode" toml:"kill_mode"`
+	Session       bool           `json:"session" yaml:"session" toml:"session"`
+	User          string         `json:"user" yaml:"user" toml:"user"`
+	Group         string         `json:"group" yaml:"group" toml:"group"`
+	Groups        []string       `json:"groups" yaml:"groups" toml:"groups"`
+	Limits        []*Rlimit      `json:"limits" yaml:"limits" toml:"limits"`
+	Cgroup        *Cgroup        `json:"cgroup" yaml:"cgroup" toml:"cgroup"`
+
+	MaxMemoryRestart Size          `json:"max_memory_restart" yaml:"max_memory_restart" toml:"max_memory_restart"`
+	MaxUptime        time.Duration `json:"max_uptime" yaml:"max_uptime" toml:"max_uptime"`
+}
+
+// LoadEcosystem reads an ecosystem file, the format is chosen by extension:
+// .yaml, .yml, .toml or .json. A relative dir is resolved against the file.
+func LoadEcosystem(path string) (*Ecosystem, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Ecosystem{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, e)
+	case ".toml":
+		err = toml.Unmarshal(data, e)
+	case ".json":
+		err = json.Unmarshal(data, e)
+	default:
+		return nil, fmt.Errorf("unsupported ecosystem format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse ecosystem %s: %w", path, err)
+	}
+
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range e.Processes {
+		if p.Dir != "" && !filepath.IsAbs(p.Dir) {
+			p.Dir = filepath.Join(base, p.Dir)
+		}
+	}
+
+	return e, e.validate()
+}
+
+func (e *Ecosystem) validate() error {
+	names := make(map[string]struct{}, len(e.Processes))
+	for _, p := range e.Processes {
+		if p.Name == "" {
+			return fmt.Errorf("process name required, cmd: %s", p.Cmd)
+		}
+		if p.Cmd == "" {
+			return fmt.Errorf("process cmd required, name: %s", p.Name)
+		}
+		if err := validateName(p.Name); err != nil {
+			return err
+		}
+		if err := p.attributes("").validate(); err != nil {
+			return fmt.Errorf("process %s: %w", p.Name, err)
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("duplicate process name: %s", p.Name)
+		}
+		names[p.Name] = struct{}{}
+	}
+
+	graph := make(map[string][]*Dependency, len(e.Processes))
+	for _, p := range e.Processes {
+		graph[p.Name] = p.DependsOn
+	}
+	for _, p := range e.Processes {
+		if err := validateDependencies(p.Name, p.DependsOn, graph); err != nil {
+			return fmt.Errorf("process %s: %w", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *EcosystemProcess) attributes(source string) *Attributes {
+	return &Attributes{
+		name:     p.Name,
+		source:   source,
+		dir:      p.Dir,
+		cmd:      p.Cmd,
+		argv:     p.Argv,
+		env:      p.Env,
+		files:    p.Files,
+		restart:  p.Restart,
+		cron:     p.Cron,
+		labels:   p.Labels,
+		capture:  p.Capture,
+		rotation: p.Rotation,
+
+		restartPolicy: p.RestartPolicy,
+		healthCheck:   p.HealthCheck,
+		readiness:     p.Readiness,
+		dependsOn:     p.DependsOn,
+		kill:          p.KillMode,
+		session:       p.Session,
+		user:          p.User,
+		group:         p.Group,
+		groups:        p.Groups,
+		limits:        p.Limits,
+		cgroup:        p.Cgroup,
+
+		maxMemoryRestart: p.MaxMemoryRestart,
+		maxUptime:        p.MaxUptime,
+	}
+}
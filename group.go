@@ -0,0 +1,124 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	KillModeProcess = "process"
+	KillModeGroup   = "group"
+	KillModeMixed   = "mixed"
+)
+
+func validateKillMode(mode string) error {
+	switch mode {
+	case "", KillModeProcess, KillModeGroup, KillModeMixed:
+		return nil
+	}
+	return fmt.Errorf("invalid kill mode: %s", mode)
+}
+
+func (a *Attributes) killMode() string {
+	if a.kill == "" {
+		return KillModeGroup
+	}
+	return a.kill
+}
+
+// sysProcAttr puts the child in a process group of its own, or a session of
+// its own, so signals reach everything it forks, running as the resolved user
+// and groups if any.
+func (a *Attributes) sysProcAttr(id *identity) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if a.session {
+		attr = &syscall.SysProcAttr{Setsid: true}
+	}
+
+	if id != nil {
+		attr.Credential = id.credential
+	}
+
+	return attr
+}
+
+// deliver sends a signal as the kill mode says: to the main process, to its
+// group, or, with mixed, SIGKILL to the group and anything else to the main
+// process. Called with p.m held.
+func (p *Process) deliver(process *os.Process, s syscall.Signal) error {
+	mode := p.attributes.killMode()
+
+	group := p.pgid > 0 && (mode == KillModeGroup || mode == KillModeMixed && s == syscall.SIGKILL)
+	if !group {
+		return process.Signal(s)
+	}
+
+	err := syscall.Kill(-p.pgid, s)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+
+	return err
+}
+
+// tree is what a stop has to take down: the process group, the descendants
+// of the main process when the stop began and the cgroup.
+type tree struct {
+	pgid        int
+	descendants []*procStat
+	cgroup      string
+}
+
+// tree takes a snapshot of the process tree of the current run, once the main
+// process exits its descendants are re-parented and can not be found anymore.
+func (p *Process) tree() *tree {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	t := &tree{}
+
+	if p.process == nil || p.attributes.killMode() == KillModeProcess {
+		return t
+	}
+
+	t.pgid = p.pgid
+	t.descendants, _ = descendants(p.process.Pid)
+	t.cgroup = p.cgroup()
+
+	return t
+}
+
+// sweep kills what survived of a stopped tree, a descendant is only killed
+// while its start time shows it is still the same process. Whatever is left
+// in the cgroup goes too.
+func (p *Process) sweep(t *tree) {
+	var killed []int
+
+	if t.pgid > 0 && syscall.Kill(-t.pgid, syscall.SIGKILL) == nil {
+		killed = append(killed, -t.pgid)
+	}
+
+	for _, d := range t.descendants {
+		startTime, err := procStartTime(d.pid)
+		if err != nil || startTime != d.startTime {
+			continue
+		}
+
+		if syscall.Kill(d.pid, syscall.SIGKILL) == nil {
+			killed = append(killed, d.pid)
+		}
+	}
+
+	if t.cgroup != "" {
+		pids, _ := killCgroup(t.cgroup)
+		killed = append(killed, pids...)
+	}
+
+	if len(killed) > 0 {
+		p.m.Lock()
+		p.pushEvent(eventKindData, fmt.Sprintf("process: %s, sweep killed leftovers: %v", p.uuid, killed))
+		p.m.Unlock()
+	}
+}
@@ -0,0 +1,304 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	HealthHTTP = "http"
+	HealthTCP  = "tcp"
+	HealthExec = "exec"
+
+	healthStarting  = "starting"
+	healthHealthy   = "healthy"
+	healthUnhealthy = "unhealthy"
+
+	healthGracefully = time.Second * 5
+
+	healthBodySize = 64 * 1024
+)
+
+// HealthCheck probes a running process every Interval. Threshold consecutive
+// failures restart it, failures within StartPeriod of the start do not count.
+type HealthCheck struct {
+	Type string `json:"type" yaml:"type" toml:"type"`
+
+	// URL is fetched with GET by an http check, the response must have
+	// ExpectStatus and contain ExpectBody.
+	URL          string `json:"url" yaml:"url" toml:"url"`
+	ExpectStatus int    `json:"expect_status" yaml:"expect_status" toml:"expect_status"`
+	ExpectBody   string `json:"expect_body" yaml:"expect_body" toml:"expect_body"`
+
+	Address string `json:"address" yaml:"address" toml:"address"`
+
+	// Exec is run by an exec check like the process, in its dir, env, cgroup
+	// and process group, with its limits and as its user, the first element
+	// is the command, it must exit with 0.
+	Exec []string `json:"exec" yaml:"exec" toml:"exec"`
+
+	Interval    time.Duration `json:"interval" yaml:"interval" toml:"interval"`
+	Timeout     time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	Threshold   int           `json:"threshold" yaml:"threshold" toml:"threshold"`
+	StartPeriod time.Duration `json:"start_period" yaml:"start_period" toml:"start_period"`
+}
+
+func (h *HealthCheck) String() string {
+	if h == nil {
+		return ""
+	}
+
+	var target string
+	switch h.Type {
+	case HealthHTTP:
+		target = h.URL
+	case HealthTCP:
+		target = h.Address
+	case HealthExec:
+		target = strings.Join(h.Exec, " ")
+	}
+
+	return fmt.Sprintf("%s %s interval=%s timeout=%s threshold=%d start_period=%s", h.Type, target, h.Interval, h.Timeout, h.Threshold, h.StartPeriod)
+}
+
+func (h *HealthCheck) validate() error {
+	switch h.Type {
+	case HealthHTTP:
+		if h.URL == "" {
+			return errors.New("http health check requires url")
+		}
+	case HealthTCP:
+		if h.Address == "" {
+			return errors.New("tcp health check requires address")
+		}
+	case HealthExec:
+		if len(h.Exec) == 0 {
+			return errors.New("exec health check requires a command")
+		}
+	default:
+		return fmt.Errorf("invalid health check type: %s", h.Type)
+	}
+
+	if h.Interval < 0 || h.Timeout < 0 || h.Threshold < 0 || h.StartPeriod < 0 {
+		return fmt.Errorf("invalid health check: %s", h)
+	}
+
+	return nil
+}
+
+func (h HealthCheck) withDefaults() *HealthCheck {
+	if h.Interval == 0 {
+		h.Interval = time.Second * 10
+	}
+	if h.Timeout == 0 {
+		h.Timeout = time.Second
+	}
+	if h.Threshold == 0 {
+		h.Threshold = 3
+	}
+	if h.ExpectStatus == 0 {
+		h.ExpectStatus = http.StatusOK
+	}
+	return &h
+}
+
+// watchHealth starts probing the current run. Called with p.m held.
+func (p *Process) watchHealth() {
+	p.health = ""
+	p.healthFailures = 0
+
+	if p.attributes.healthCheck == nil {
+		return
+	}
+
+	p.health = healthStarting
+
+	go p.probe(p.attributes.healthCheck, p.startedAt, p.done)
+}
+
+// probe checks the run that closes done until it ends, and restarts the
+// process once the check failed Threshold times in a row.
+func (p *Process) probe(check *HealthCheck, started time.Time, done chan struct{}) {
+	check = check.withDefaults()
+
+	t := time.NewTicker(check.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		p.m.Lock()
+		running := p.status == statusRunning
+		attributes, cgroup := p.attributes, p.cgroup()
+		p.m.Unlock()
+
+		if !running {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), check.Timeout)
+		err := p.manager.check(ctx, check, attributes, cgroup)
+		cancel()
+
+		p.m.Lock()
+
+		if err == nil {
+			p.health = healthHealthy
+			p.healthFailures = 0
+			p.m.Unlock()
+			continue
+		}
+
+		if time.Since(started) < check.StartPeriod {
+			p.m.Unlock()
+			continue
+		}
+
+		p.healthFailures++
+		if p.healthFailures < check.Threshold {
+			p.m.Unlock()
+			continue
+		}
+
+		p.health = healthUnhealthy
+		p.healthRestarts++
+		p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, health check failed %d times, restart: %s", p.uuid, p.healthFailures, err))
+		p.m.Unlock()
+
+		ctx, cancel = context.WithTimeout(context.Background(), operateTimeout+healthGracefully)
+		_, err = p.manager.Operate(ctx, &OperateRestart{
+			ID:         p.uuid,
+			Gracefully: healthGracefully,
+		})
+		cancel()
+
+		if err != nil {
+			p.m.Lock()
+			p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, unhealthy restart failed: %s", p.uuid, err))
+			p.m.Unlock()
+		}
+
+		return
+	}
+}
+
+func (m *Manager) check(ctx context.Context, check *HealthCheck, attributes *Attributes, cgroup string) error {
+	switch check.Type {
+	case HealthHTTP:
+		return checkHTTP(ctx, check)
+	case HealthTCP:
+		return checkTCP(ctx, check)
+	case HealthExec:
+		return m.checkExec(ctx, check, attributes, cgroup)
+	}
+
+	return fmt.Errorf("invalid health check type: %s", check.Type)
+}
+
+func checkHTTP(ctx context.Context, check *HealthCheck) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, healthBodySize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != check.ExpectStatus {
+		return fmt.Errorf("http status %d, expect %d", resp.StatusCode, check.ExpectStatus)
+	}
+
+	if check.ExpectBody != "" && !strings.Contains(string(body), check.ExpectBody) {
+		return fmt.Errorf("http body does not contain %q", check.ExpectBody)
+	}
+
+	return nil
+}
+
+func checkTCP(ctx context.Context, check *HealthCheck) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", check.Address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// checkExec runs the check command as a child of the manager, so it goes
+// through the reaper like every other child. It is started like the process,
+// a check must not get more than the process it checks.
+func (m *Manager) checkExec(ctx context.Context, check *HealthCheck, attributes *Attributes, cgroup string) error {
+	cmd, err := exec.LookPath(check.Exec[0])
+	if err != nil {
+		return err
+	}
+
+	id, err := attributes.identity()
+	if err != nil {
+		return err
+	}
+
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+
+	process, err := m.reaper.start(func() (*os.Process, error) {
+		return attributes.startCommand(cmd, check.Exec, id, cgroup, id.env(attributes.env), []*os.File{null, null, null})
+	})
+	if err != nil {
+		return err
+	}
+
+	// the check leads a group of its own, anything it forks goes with it.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = syscall.Kill(-process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	state, err := process.Wait()
+	close(done)
+
+	m.reaper.release(process.Pid)
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("exec %s: %w", check.Exec[0], ctx.Err())
+	}
+	if err != nil {
+		return err
+	}
+	if !state.Success() {
+		return fmt.Errorf("exec %s: %s", check.Exec[0], state)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package process
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	statusCreated   = "created"
+	statusStarting  = "starting"
+	statusRunning   = "running"
+	statusStopping  = "stopping"
+	statusExited    = "exited"
+	statusFailed    = "failed"
+	statusBackoff   = "backoff"
+	statusScheduled = "scheduled"
+	statusFatal     = "fatal"
+	statusWaiting   = "waiting"
+)
+
+var transitions = map[string][]string{
+	statusCreated:   {statusStarting, statusRunning, statusScheduled, statusWaiting},
+	statusStarting:  {statusRunning, statusFailed},
+	statusRunning:   {statusStopping, statusExited, statusFailed},
+	statusStopping:  {statusExited, statusFailed},
+	statusExited:    {statusStarting, statusBackoff, statusScheduled, statusFatal, statusWaiting},
+	statusFailed:    {statusStarting, statusBackoff, statusScheduled, statusFatal, statusWaiting},
+	statusBackoff:   {statusStarting, statusExited, statusFailed},
+	statusScheduled: {statusStarting, statusWaiting},
+	statusFatal:     {statusStarting, statusScheduled, statusWaiting},
+	statusWaiting:   {statusStarting, statusCreated, statusExited, statusFailed, statusScheduled, statusFatal},
+}
+
+func validStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// transition moves the process to the given state, a move the lifecycle does
+// not allow is refused. Called with p.m held.
+func (p *Process) transition(to string) error {
+	if p.status == to {
+		return nil
+	}
+
+	for _, status := range transitions[p.status] {
+		if status == to {
+			logrus.WithField("uuid", p.uuid).WithField("from", p.status).WithField("to", to).Debug("process transition")
+
+			p.status = to
+			p.since = time.Now()
+
+			p.changed()
+
+			return nil
+		}
+	}
+
+	err := conflict(fmt.Errorf("process: %s, invalid transition from %s to %s", p.uuid, p.status, to))
+
+	logrus.WithField("uuid", p.uuid).WithError(err).Warn("process transition refused")
+
+	return err
+}
+
+// isRunning reports whether the process has a live run, including one that
+// is being stopped.
+func (p *Process) isRunning() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.status == statusRunning || p.status == statusStopping
+}
+
+func (p *Process) stopping() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.status == statusRunning {
+		_ = p.transition(statusStopping)
+	}
+}
@@ -0,0 +1,78 @@
+package process
+
+import "testing"
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{statusCreated, statusStarting, true},
+		{statusCreated, statusRunning, true},
+		{statusCreated, statusScheduled, true},
+		{statusCreated, statusExited, false},
+		{statusStarting, statusRunning, true},
+		{statusStarting, statusFailed, true},
+		{statusStarting, statusStopping, false},
+		{statusRunning, statusStopping, true},
+		{statusRunning, statusExited, true},
+		{statusRunning, statusFailed, true},
+		{statusRunning, statusStarting, false},
+		{statusRunning, statusBackoff, false},
+		{statusStopping, statusExited, true},
+		{statusStopping, statusRunning, false},
+		{statusExited, statusStarting, true},
+		{statusExited, statusBackoff, true},
+		{statusExited, statusFatal, true},
+		{statusExited, statusRunning, false},
+		{statusFailed, statusBackoff, true},
+		{statusFailed, statusStopping, false},
+		{statusBackoff, statusStarting, true},
+		{statusBackoff, statusFailed, true},
+		{statusBackoff, statusRunning, false},
+		{statusScheduled, statusStarting, true},
+		{statusScheduled, statusRunning, false},
+		{statusFatal, statusStarting, true},
+		{statusFatal, statusBackoff, false},
+		{statusRunning, statusRunning, true},
+	}
+
+	for _, test := range tests {
+		p := NewManager().newProcess("test", &Attributes{})
+		p.status = test.from
+		since, change := p.since, p.change
+
+		err := p.transition(test.to)
+
+		if !test.allowed {
+			if err == nil {
+				t.Errorf("%s -> %s: allowed", test.from, test.to)
+			}
+			if p.status != test.from {
+				t.Errorf("%s -> %s: refused, but status %s", test.from, test.to, p.status)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s -> %s: %s", test.from, test.to, err)
+			continue
+		}
+		if p.status != test.to {
+			t.Errorf("%s -> %s: status %s", test.from, test.to, p.status)
+		}
+
+		// a move wakes up the waiters, staying put does not.
+		select {
+		case <-change:
+			if test.from == test.to {
+				t.Errorf("%s -> %s: change closed", test.from, test.to)
+			}
+		default:
+			if test.from != test.to || p.since != since {
+				t.Errorf("%s -> %s: change not closed", test.from, test.to)
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package process
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+
+	logBufferSize = 1000
+	logLineSize   = 64 * 1024
+)
+
+type LogLine struct {
+	Seq    uint64
+	Time   time.Time
+	Stream string
+	Text   string
+}
+
+// logBuffer keeps the last lines written by a process, every line gets a
+// sequence number so readers can resume where they left off.
+type logBuffer struct {
+	m       sync.Mutex
+	lines   []*LogLine
+	head    int
+	next    uint64
+	changed chan struct{}
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{
+		lines:   make([]*LogLine, 0, size),
+		next:    1,
+		changed: make(chan struct{}),
+	}
+}
+
+func (b *logBuffer) append(stream, text string) *LogLine {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	line := &LogLine{
+		Seq:    b.next,
+		Time:   time.Now(),
+		Stream: stream,
+		Text:   text,
+	}
+	b.next++
+
+	if len(b.lines) < cap(b.lines) {
+		b.lines = append(b.lines, line)
+	} else {
+		b.lines[b.head] = line
+		b.head = (b.head + 1) % len(b.lines)
+	}
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+
+	return line
+}
+
+func (b *logBuffer) cursor() uint64 {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.next
+}
+
+// read returns the lines of the given streams starting at cursor, or the last
+// tail lines when cursor is zero, along with the cursor to continue from and
+// a channel closed on the next append.
+func (b *logBuffer) read(cursor uint64, tail int, streams map[string]bool) ([]*LogLine, uint64, <-chan struct{}) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var lines []*LogLine
+	for i := 0; i < len(b.lines); i++ {
+		line := b.lines[(b.head+i)%len(b.lines)]
+		if line.Seq < cursor || !streams[line.Stream] {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if cursor == 0 && tail >= 0 && len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+
+	return lines, b.next, b.changed
+}
+
+type output struct {
+	stream string
+	reader *os.File
+	writer *os.File
+	sink   *rotateWriter
+}
+
+// openOutputs replaces stdout and stderr of files with pipes. The write ends go
+// to the child, the read ends are drained into the log buffer and into sinks
+// wrapping the original files, which are owned by the sinks from now on.
+func openOutputs(files []*os.File, paths []string, rotation *Rotation) ([]*os.File, []*output, []*rotateWriter, error) {
+	child := make([]*os.File, len(files))
+	copy(child, files)
+	for len(child) < 3 {
+		child = append(child, nil)
+	}
+
+	var (
+		outputs []*output
+		sinks   []*rotateWriter
+	)
+
+	for fd, stream := range []string{1: streamStdout, 2: streamStderr} {
+		if stream == "" {
+			continue
+		}
+
+		r, w, err := os.Pipe()
+		if err != nil {
+			for _, o := range outputs {
+				_ = o.reader.Close()
+				_ = o.writer.Close()
+			}
+			return nil, nil, nil, err
+		}
+
+		o := &output{
+			stream: stream,
+			reader: r,
+			writer: w,
+		}
+
+		if child[fd] != nil {
+			for _, sink := range sinks {
+				if sink.path == paths[fd] {
+					o.sink = sink
+				}
+			}
+
+			if o.sink == nil {
+				o.sink = newRotateWriter(paths[fd], child[fd], rotation)
+				sinks = append(sinks, o.sink)
+			} else {
+				_ = child[fd].Close()
+			}
+		}
+
+		outputs = append(outputs, o)
+
+		child[fd] = w
+	}
+
+	return child, outputs, sinks, nil
+}
+
+// drain copies the output of a process line by line, until every writer,
+// including those inherited by descendants, is closed.
+func (p *Process) drain(o *output) {
+	defer o.reader.Close()
+
+	r := bufio.NewReaderSize(o.reader, logLineSize)
+	for {
+		data, _, err := r.ReadLine()
+		if len(data) > 0 || err == nil {
+			line := p.logs.append(o.stream, string(data))
+
+			if o.sink != nil {
+				_, _ = fmt.Fprintf(o.sink, "%s %s %s\n", line.Time.Format(time.RFC3339Nano), line.Stream, line.Text)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *Process) drainOutputs(outputs []*output, sinks []*rotateWriter) {
+	var wg sync.WaitGroup
+
+	for _, o := range outputs {
+		wg.Add(1)
+		go func(o *output) {
+			defer wg.Done()
+			p.drain(o)
+		}(o)
+	}
+
+	wg.Wait()
+
+	for _, sink := range sinks {
+		_ = sink.Close()
+	}
+}
+
+func (p *Process) rotate() error {
+	p.m.Lock()
+	sinks := p.sinks
+	capture := p.attributes.capture
+	p.m.Unlock()
+
+	if !capture {
+		return conflict(fmt.Errorf("process: %s, output not captured, it can not be rotated", p.uuid))
+	}
+
+	for _, sink := range sinks {
+		err := sink.Rotate()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Logs reads the captured output of a process starting at cursor, or its last
+// tail lines when cursor is zero. Without a new line it waits up to wait for
+// one, which lets clients follow the output by polling with the cursor.
+func (m *Manager) Logs(id string, cursor uint64, tail int, streams map[string]bool, wait time.Duration) ([]*LogLine, uint64, error) {
+	process, err := m.searchProcess(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	lines, next, changed := process.logs.read(cursor, tail, streams)
+	if len(lines) > 0 || wait <= 0 {
+		return lines, next, nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	for len(lines) == 0 {
+		select {
+		case <-t.C:
+			return lines, next, nil
+		case <-changed:
+			lines, next, changed = process.logs.read(next, tail, streams)
+		}
+	}
+
+	return lines, next, nil
+}
+
+// Rotate forces a rotation of the captured output files of a process.
+func (m *Manager) Rotate(id string) error {
+	process, err := m.searchProcess(id)
+	if err != nil {
+		return err
+	}
+
+	return process.rotate()
+}
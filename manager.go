@@ -3,7 +3,12 @@ package process
 import (
 	"container/ring"
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -17,16 +22,101 @@ import (
 type Manager struct {
 	lock           sync.Mutex
 	processes      map[string]*Process
-	operateChannel chan interface{}
+	operateChannel chan *request
+	done           chan struct{}
 	cron           *cron.Cron
+	state          string
+	relaunch       bool
+	reaper         *reaper
+	changeLock     sync.Mutex
+	change         chan struct{}
+	cgroupParent   string
+
+	statsDescendants bool
+	metrics          *metrics
 }
 
-func NewManager() *Manager {
-	return &Manager{
+type Option func(m *Manager)
+
+var (
+	// ErrNotFound is the error of an operation on a process the manager does
+	// not know.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is the error of an operation the state of the process does
+	// not allow, or of a start with a name in use.
+	ErrConflict = errors.New("conflict")
+)
+
+// kindError gives an error a kind errors.Is matches, keeping its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func conflict(err error) error {
+	return &kindError{kind: ErrConflict, err: err}
+}
+
+// WithState persists process definitions to the given file and restores them
+// when the manager runs.
+func WithState(path string) Option {
+	return func(m *Manager) {
+		m.state = path
+	}
+}
+
+// WithRelaunch starts the restored processes again when the manager runs.
+func WithRelaunch(relaunch bool) Option {
+	return func(m *Manager) {
+		m.relaunch = relaunch
+	}
+}
+
+// WithCgroupParent puts every process in a cgroup v2 of its own under the
+// given delegated directory, for limits and accounting.
+func WithCgroupParent(path string) Option {
+	return func(m *Manager) {
+		m.cgroupParent = path
+	}
+}
+
+// WithStatsDescendants sums the resource usage of a process over its
+// descendants.
+func WithStatsDescendants(descendants bool) Option {
+	return func(m *Manager) {
+		m.statsDescendants = descendants
+	}
+}
+
+func NewManager(options ...Option) *Manager {
+	m := &Manager{
 		processes:      make(map[string]*Process),
-		operateChannel: make(chan interface{}, 1024),
+		operateChannel: make(chan *request, 1024),
+		done:           make(chan struct{}),
 		cron:           cron.New(cron.WithSeconds()),
+		reaper:         newReaper(),
+		change:         make(chan struct{}),
+		metrics:        newMetrics(),
+	}
+
+	for _, option := range options {
+		option(m)
 	}
+
+	return m
 }
 
 func (m *Manager) List() []*Metadata {
@@ -40,195 +130,634 @@ func (m *Manager) List() []*Metadata {
 	return metadata
 }
 
-func (m *Manager) Operate(operate interface{}, timeout time.Duration) error {
-	logrus.WithField("timeout", timeout).WithField("operate", operate).Debug("operate receive")
+// Operate queues an operation and waits for its outcome, ctx bounds both the
+// wait in the queue and the operation itself and carries the caller, see
+// WithPeer.
+func (m *Manager) Operate(ctx context.Context, operate interface{}) (*Outcome, error) {
+	logrus.WithField("operate", operate).Debug("operate receive")
+
+	req := &request{
+		ctx:     ctx,
+		operate: operate,
+		reply:   make(chan *response, 1),
+		queued:  time.Now(),
+	}
 
-	tm := time.NewTimer(timeout)
-	defer tm.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("operate timeout operate: %v: %w", operate, ctx.Err())
+	case <-m.done:
+		return nil, fmt.Errorf("manager stopped operate: %v", operate)
+	case m.operateChannel <- req:
+	}
 
 	select {
-	case <-tm.C:
-		return fmt.Errorf("operate timeout operate: %v", operate)
-	case m.operateChannel <- operate:
-		return nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("operate timeout operate: %v: %w", operate, ctx.Err())
+	case <-m.done:
+		return nil, fmt.Errorf("manager stopped operate: %v", operate)
+	case resp := <-req.reply:
+		return resp.outcome, resp.err
 	}
 }
 
-func (m *Manager) handlerOperate(operate interface{}) {
+func (m *Manager) handlerOperate(ctx context.Context, operate interface{}) (*Outcome, error) {
 	switch operate.(type) {
 	case *OperateStart:
 		opt := operate.(*OperateStart)
 
-		process, err := m.createProcess(opt.Dir, opt.Cmd, opt.Argv, opt.Env, opt.Files, opt.Restart, opt.Cron)
+		process, err := m.createProcess(opt.attributes())
 		if err != nil {
-			return
+			return nil, err
 		}
 
-		if opt.Cron != "" {
-			_, _ = m.cron.AddFunc(opt.Cron, func() {
-				m.startProcess(process)
-			})
-			return
+		err = m.launchProcess(process, true)
+		if err != nil {
+			m.removeProcess(process.uuid)
+			return nil, err
+		}
+
+		return process.outcome(), nil
+
+	case *OperateApply:
+		opt := operate.(*OperateApply)
+
+		changes, err := m.applyProcesses(ctx, opt)
+
+		return &Outcome{Changes: changes}, err
+
+	case *OperateSignal:
+		opt := operate.(*OperateSignal)
+		process, err := m.searchProcess(opt.ID)
+		if err != nil {
+			return nil, err
 		}
 
-		m.startProcess(process)
+		return process.outcome(), m.signalProcess(process, opt.Signal)
 
+	case *operateRetry:
+		opt := operate.(*operateRetry)
+		process, err := m.searchProcess(opt.id)
+		if err != nil {
+			return nil, err
+		}
+
+		return process.outcome(), m.retry(process, opt.run)
+
+	case *operateLaunch:
+		opt := operate.(*operateLaunch)
+		process, err := m.searchProcess(opt.id)
+		if err != nil {
+			return nil, err
+		}
+
+		return process.outcome(), m.launch(process, opt.pending)
+
+	case *operateStep:
+		opt := operate.(*operateStep)
+
+		return nil, opt.fn()
+	}
+
+	return nil, fmt.Errorf("unknown operate: %T", operate)
+}
+
+// handlerWait runs the operations that wait for processes to exit. It runs
+// off the operate loop, what changes the manager goes through m.step.
+func (m *Manager) handlerWait(ctx context.Context, operate interface{}) (*Outcome, error) {
+	switch operate.(type) {
 	case *OperateKill:
 		opt := operate.(*OperateKill)
-		process, err := m.searchProcess(opt.UUID)
+		process, err := m.searchProcess(opt.ID)
 		if err != nil {
-			return
+			return nil, err
 		}
 
-		m.killProcess(process, opt.Prune)
+		return process.outcome(), m.halt(ctx, process, syscall.SIGKILL, 0, opt.Prune, m.step)
 
 	case *OperateStop:
 		opt := operate.(*OperateStop)
-		process, err := m.searchProcess(opt.UUID)
+		process, err := m.searchProcess(opt.ID)
 		if err != nil {
-			return
+			return nil, err
 		}
 
-		m.stopProcess(process, opt.Gracefully, opt.Prune)
+		return process.outcome(), m.halt(ctx, process, syscall.SIGTERM, opt.Gracefully, opt.Prune, m.step)
 
 	case *OperateRestart:
 		opt := operate.(*OperateRestart)
-		process, err := m.searchProcess(opt.UUID)
+		process, err := m.searchProcess(opt.ID)
 		if err != nil {
-			return
+			return nil, err
 		}
 
-		m.restartProcess(process, opt.Gracefully)
-
-	case *OperateSignal:
-		opt := operate.(*OperateSignal)
-		process, err := m.searchProcess(opt.UUID)
-		if err != nil {
-			return
+		if opt.Cascade {
+			err = m.cascadeRestart(ctx, process, opt.Gracefully)
+		} else {
+			err = m.restartProcess(ctx, process, opt.Gracefully)
 		}
 
-		m.signalProcess(process, opt.Signal)
+		return process.outcome(), err
 	}
+
+	return nil, fmt.Errorf("unknown operate: %T", operate)
+}
+
+// step runs fn in the operate loop. The steps of an operation waiting off the
+// loop are not bound by its context, a stop that timed out is still swept.
+func (m *Manager) step(fn func() error) error {
+	_, err := m.Operate(context.Background(), &operateStep{fn: fn})
+	return err
+}
+
+func inLoop(fn func() error) error {
+	return fn()
 }
 
 func (m *Manager) Run(ctx context.Context) error {
 	defer func() {
 		m.cron.Stop()
 
-		close(m.operateChannel)
+		close(m.done)
 	}()
 
+	err := setSubreaper()
+	if err != nil {
+		logrus.WithError(err).Warn("set child subreaper failed")
+	}
+
+	if m.cgroupParent != "" {
+		err = prepareCgroupParent(m.cgroupParent)
+		if err != nil {
+			return err
+		}
+	}
+
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	defer signal.Stop(sigchld)
+
+	err = m.restore()
+	if err != nil {
+		return err
+	}
+
 	m.cron.Start()
 
+	go m.sampleLoop(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 
-		case operate := <-m.operateChannel:
-			m.handlerOperate(operate)
+		case <-sigchld:
+			for _, pid := range m.reaper.reap() {
+				logrus.WithField("pid", pid).Debug("orphan reaped")
+			}
+
+		case req := <-m.operateChannel:
+			err := req.ctx.Err()
+			if err != nil {
+				req.reply <- &response{err: fmt.Errorf("operate timeout operate: %v: %w", req.operate, err)}
+				continue
+			}
+
+			switch req.operate.(type) {
+			case *OperateKill, *OperateStop, *OperateRestart:
+				// these wait for processes to exit, off the loop so a slow stop
+				// holds up no other operation.
+				go func(req *request) {
+					outcome, err := m.handlerWait(req.ctx, req.operate)
+					m.reply(req, outcome, err)
+				}(req)
+				continue
+			}
+
+			outcome, err := m.handlerOperate(req.ctx, req.operate)
+			m.reply(req, outcome, err)
 		}
 	}
 }
 
-func (m *Manager) createProcess(dir, cmd string, argv, env, files []string, restart bool, cron string) (*Process, error) {
+func (m *Manager) reply(req *request, outcome *Outcome, err error) {
+	m.metrics.observe(operationName(req.operate), time.Since(req.queued), err)
+
+	// operations of callers are recorded with who asked for them.
+	if peer := PeerFromContext(req.ctx); peer != nil {
+		entry := logrus.WithField("peer", peer.String()).WithField("operate", req.operate)
+		if err != nil {
+			entry = entry.WithError(err)
+		}
+		entry.Info("operate by peer")
+	}
+
+	req.reply <- &response{outcome: outcome, err: err}
+}
+
+func (m *Manager) restore() error {
+	if m.state == "" {
+		return nil
+	}
+
+	s, err := readState(m.state)
+	if err != nil {
+		return err
+	}
+
+	processes := make([]*Process, 0, len(s.Processes))
+	relaunches := make(map[*Process]bool, len(s.Processes))
+
+	m.lock.Lock()
+	for _, d := range s.Processes {
+		process := m.newProcess(d.UUID, d.attributes())
+		process.stopped = d.Stopped
+
+		// an unless-stopped process that was stopped stays down.
+		relaunch := m.relaunch && !(process.stopped && process.attributes.policy().Policy == RestartUnlessStopped)
+		relaunches[process] = relaunch
+
+		if d.Pid > 0 {
+			startTime, err := procStartTime(d.Pid)
+			switch {
+			case err != nil || startTime != d.StartTime:
+			case d.Capture && relaunch:
+				// the read ends of its output pipes closed with the previous
+				// manager, its next write would fail: it is relaunched
+				// instead.
+				logrus.WithField("uuid", process.uuid).WithField("pid", d.Pid).Info("process captured, kill instead of adopt")
+				err = killOrphan(d.Pid, process.cgroup())
+			default:
+				err = process.adopt(d.Pid, d.StartTime)
+			}
+			if err != nil {
+				logrus.WithField("uuid", process.uuid).WithField("pid", d.Pid).WithError(err).Debug("process adopt failed")
+			}
+		}
+
+		m.processes[process.uuid] = process
+		processes = append(processes, process)
+	}
+	m.lock.Unlock()
+
+	for _, process := range processes {
+		relaunch := relaunches[process]
+
+		logrus.WithField("uuid", process.uuid).WithField("relaunch", relaunch).Debug("process restore")
+
+		err := m.launchProcess(process, relaunch)
+		if err != nil {
+			logrus.WithField("uuid", process.uuid).WithError(err).Error("process relaunch failed")
+		}
+	}
+
+	m.persist()
+
+	return nil
+}
+
+// killOrphan kills a process a previous manager left behind and whatever is in
+// its cgroup, whose leaf is removed so the relaunch starts from a fresh one.
+func killOrphan(pid int, cgroup string) error {
+	var err error
+	if s, e := readProcStat(pid); e == nil && s.pgrp == pid {
+		err = syscall.Kill(-pid, syscall.SIGKILL)
+	} else {
+		err = syscall.Kill(pid, syscall.SIGKILL)
+	}
+
+	if cgroup != "" {
+		_, e := killCgroup(cgroup)
+		if e == nil {
+			removeCgroup(cgroup)
+		}
+	}
+
+	return err
+}
+
+func (m *Manager) persist() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.saveState()
+}
+
+func (m *Manager) saveState() {
+	if m.state == "" {
+		return
+	}
+
+	s := &state{
+		Version:   stateVersion,
+		Processes: make([]*definition, 0, len(m.processes)),
+	}
+	for _, p := range m.processes {
+		s.Processes = append(s.Processes, p.definition())
+	}
+
+	sort.Slice(s.Processes, func(i, j int) bool {
+		return s.Processes[i].UUID < s.Processes[j].UUID
+	})
+
+	err := writeState(m.state, s)
+	if err != nil {
+		logrus.WithField("state", m.state).WithError(err).Error("state write failed")
+	}
+}
+
+func (m *Manager) createProcess(attributes *Attributes) (*Process, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	process := &Process{
-		manager: m,
-		uuid:    uuid.New().String(),
-		attributes: &Attributes{
-			dir:     dir,
-			cmd:     cmd,
-			argv:    argv,
-			env:     env,
-			files:   files,
-			restart: restart,
-			cron:    cron,
-		},
+	err := attributes.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if attributes.name != "" {
+		err := validateName(attributes.name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, process := range m.processes {
+			if process.attributes.name == attributes.name {
+				return nil, conflict(fmt.Errorf("process name already in use: %s", attributes.name))
+			}
+		}
+	}
+
+	err = validateDependencies(attributes.name, attributes.dependsOn, m.dependencyGraph())
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.admit(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	process := m.newProcess(uuid.New().String(), attributes)
+
+	m.processes[process.uuid] = process
+
+	m.saveState()
+
+	m.changed()
+
+	return process, nil
+}
+
+// admit checks what validate leaves to the manager: the users and groups
+// exist here and cgroup limits have a cgroup parent.
+func (m *Manager) admit(attributes *Attributes) error {
+	_, err := attributes.identity()
+	if err != nil {
+		return err
+	}
+
+	if attributes.cgroup != nil && m.cgroupParent == "" {
+		return fmt.Errorf("cgroup limits require a cgroup parent")
+	}
+
+	return nil
+}
+
+func (m *Manager) newProcess(id string, attributes *Attributes) *Process {
+	return &Process{
+		manager:      m,
+		uuid:         id,
+		attributes:   attributes,
 		process:      nil,
 		processState: nil,
 		files:        nil,
 		events:       ring.New(10),
+		logs:         newLogBuffer(logBufferSize),
+		status:       statusCreated,
+		since:        time.Now(),
+		change:       make(chan struct{}),
 	}
+}
 
-	m.processes[process.uuid] = process
+func (m *Manager) searchName(name string) *Process {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
-	return process, nil
+	for _, process := range m.processes {
+		if process.attributes.name == name {
+			return process
+		}
+	}
+
+	return nil
 }
 
-func (m *Manager) searchProcess(uuid string) (*Process, error) {
+func (m *Manager) searchSource(source string) []*Process {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	process, ok := m.processes[uuid]
-	if !ok {
-		return nil, fmt.Errorf("not found process: %s", uuid)
+	var processes []*Process
+	for _, process := range m.processes {
+		if process.attributes.source == source {
+			processes = append(processes, process)
+		}
 	}
 
-	return process, nil
+	sort.Slice(processes, func(i, j int) bool {
+		return processes[i].attributes.name < processes[j].attributes.name
+	})
+
+	return processes
 }
 
-func (m *Manager) removeProcess(uuid string) {
+func (m *Manager) searchProcess(id string) (*Process, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	delete(m.processes, uuid)
+	process, ok := m.processes[id]
+	if ok {
+		return process, nil
+	}
+
+	if id != "" {
+		for _, process := range m.processes {
+			if process.attributes.name == id {
+				return process, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w process: %s", ErrNotFound, id)
 }
 
-func (m *Manager) startProcess(process *Process) {
-	if process.isRunning() {
+// validateName rejects names that can not be told apart from a uuid.
+func validateName(name string) error {
+	if strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("invalid process name: %q", name)
+	}
+
+	_, err := uuid.Parse(name)
+	if err == nil {
+		return fmt.Errorf("invalid process name, looks like a uuid: %s", name)
+	}
+
+	return nil
+}
+
+func (m *Manager) removeProcess(uuid string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	process, ok := m.processes[uuid]
+	if !ok {
 		return
 	}
 
-	process.start()
+	process.m.Lock()
+	entry := process.cronEntry
+	process.m.Unlock()
+
+	if entry != 0 {
+		m.cron.Remove(entry)
+	}
+
+	delete(m.processes, uuid)
+
+	if cgroup := process.cgroup(); cgroup != "" {
+		removeCgroup(cgroup)
+	}
+
+	m.saveState()
+
+	m.changed()
 }
 
-func (m *Manager) killProcess(process *Process, prune bool) {
-	defer func() {
-		if prune {
-			m.removeProcess(process.uuid)
+// launchProcess schedules a cron process, other processes are started
+// immediately when start is set.
+func (m *Manager) launchProcess(process *Process, start bool) error {
+	if process.attributes.cron != "" {
+		entry, err := m.cron.AddFunc(process.attributes.cron, func() {
+			process.m.Lock()
+			process.cronRuns++
+			process.m.Unlock()
+
+			err := m.startProcess(process)
+			if err != nil {
+				process.m.Lock()
+				process.cronFailures++
+				process.m.Unlock()
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("invalid cron %q: %w", process.attributes.cron, err)
 		}
-	}()
 
-	if !process.isRunning() {
-		return
+		process.m.Lock()
+		process.cronEntry = entry
+		if process.status != statusRunning && process.status != statusStopping {
+			_ = process.transition(statusScheduled)
+		}
+		process.m.Unlock()
+
+		return nil
+	}
+
+	if start {
+		return m.startProcess(process)
 	}
 
-	process.signal(syscall.SIGKILL)
+	return nil
 }
 
-func (m *Manager) stopProcess(process *Process, gracefully time.Duration, prune bool) {
-	defer func() {
-		if prune {
-			m.removeProcess(process.uuid)
+func (m *Manager) startProcess(process *Process) error {
+	if process.isRunning() {
+		return nil
+	}
+
+	process.resetBackoff()
+
+	unmet := m.unmet(process)
+	if len(unmet) > 0 {
+		m.deferStart(process, unmet)
+		return nil
+	}
+
+	err := process.start()
+
+	m.persist()
+
+	return err
+}
+
+// stopProcess stops the process and waits for it in the operate loop, for an
+// apply no other operation may interleave with.
+func (m *Manager) stopProcess(ctx context.Context, process *Process, gracefully time.Duration, prune bool) error {
+	return m.halt(ctx, process, syscall.SIGTERM, gracefully, prune, inLoop)
+}
+
+// halt sends s to the process, SIGKILL once gracefully passed, and waits for
+// it to exit. What changes the manager runs through step, the wait in between
+// does not.
+func (m *Manager) halt(ctx context.Context, process *Process, s syscall.Signal, gracefully time.Duration, prune bool, step func(func() error) error) error {
+	var t *tree
+
+	err := step(func() error {
+		process.markStopped()
+
+		if !process.isRunning() {
+			return nil
 		}
-	}()
 
-	if !process.isRunning() {
-		return
+		process.stopping()
+
+		t = process.tree()
+
+		return process.signal(s)
+	})
+
+	if err == nil && t != nil {
+		if s == syscall.SIGKILL {
+			err = process.wait(ctx)
+		} else {
+			m.do(gracefully, func() {
+				err = process.wait(ctx)
+			}, func() {
+				_ = process.signal(syscall.SIGKILL)
+			})
+		}
 	}
 
-	m.do(gracefully, func() {
-		process.signal(syscall.SIGTERM)
-	}, func() {
-		process.signal(syscall.SIGKILL)
+	_ = step(func() error {
+		if err == nil && t != nil {
+			process.sweep(t)
+		}
+		if prune {
+			m.removeProcess(process.uuid)
+		}
+		return nil
 	})
+
+	return err
 }
 
-func (m *Manager) restartProcess(process *Process, gracefully time.Duration) {
-	m.stopProcess(process, gracefully, false)
+func (m *Manager) restartProcess(ctx context.Context, process *Process, gracefully time.Duration) error {
+	err := m.halt(ctx, process, syscall.SIGTERM, gracefully, false, m.step)
+	if err != nil {
+		return err
+	}
 
-	m.startProcess(process)
+	return m.step(func() error {
+		return m.startProcess(process)
+	})
 }
 
-func (m *Manager) signalProcess(process *Process, signal syscall.Signal) {
+func (m *Manager) signalProcess(process *Process, signal syscall.Signal) error {
 	if !process.isRunning() {
-		return
+		return conflict(fmt.Errorf("process not running: %s", process.uuid))
 	}
 
-	process.signal(signal)
+	return process.signal(signal)
 }
 
 func (m *Manager) do(timeout time.Duration, handler, failed func()) {
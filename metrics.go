@@ -0,0 +1,276 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var operateBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// metrics records what the manager itself does, the process metrics are taken
+// from the processes on every scrape.
+type metrics struct {
+	lock    sync.Mutex
+	operate map[string]*histogram
+	errors  map[string]uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		operate: make(map[string]*histogram),
+		errors:  make(map[string]uint64),
+	}
+}
+
+func (m *metrics) observe(operation string, d time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	h, ok := m.operate[operation]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(operateBuckets))}
+		m.operate[operation] = h
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range operateBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	if err != nil {
+		m.errors[operation]++
+	}
+}
+
+func operationName(operate interface{}) string {
+	name := fmt.Sprintf("%T", operate)
+	name = name[strings.LastIndex(name, ".")+1:]
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "Operate"), "operate")
+	return strings.ToLower(name)
+}
+
+// MetricsHandler serves the metrics of the manager and its processes in the
+// Prometheus text format.
+func MetricsHandler(m *Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.writeMetrics(w)
+	})
+}
+
+// readHeaderTimeout bounds how long a client of an http service may take to
+// send the headers of a request.
+const readHeaderTimeout = time.Second * 10
+
+// ServeMetrics serves MetricsHandler under /metrics. The metrics are not
+// authenticated, a tcp listener other than loopback is refused.
+func ServeMetrics(ctx context.Context, m *Manager, listener net.Listener) error {
+	err := checkExposure("metrics", listener, nil)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler(m))
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (m *Manager) writeMetrics(w io.Writer) {
+	metadata := m.List()
+	sort.Slice(metadata, func(i, j int) bool {
+		if metadata[i].Name != metadata[j].Name {
+			return metadata[i].Name < metadata[j].Name
+		}
+		return metadata[i].UUID < metadata[j].UUID
+	})
+
+	family := func(name, kind, help string, value func(md *Metadata) (float64, bool)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, md := range metadata {
+			v, ok := value(md)
+			if ok {
+				fmt.Fprintf(w, "%s{%s} %g\n", name, metricLabels(md, nil), v)
+			}
+		}
+	}
+
+	family("managed_process_up", "gauge", "Whether the process is running.", func(md *Metadata) (float64, bool) {
+		return metricBool(md.Alive), true
+	})
+	family("managed_process_restarts_total", "counter", "Restarts by the restart policy after the process exited, health check restarts and recycles have their own families.", func(md *Metadata) (float64, bool) {
+		return float64(md.Restarts), true
+	})
+	family("managed_process_health_restarts_total", "counter", "Restarts for failing the health check.", func(md *Metadata) (float64, bool) {
+		return float64(md.HealthRestarts), md.HealthCheck != nil
+	})
+	family("managed_process_last_exit_code", "gauge", "Exit code of the last run, -1 when unknown.", func(md *Metadata) (float64, bool) {
+		return float64(md.ExitCode), md.ExitData != ""
+	})
+	family("managed_process_start_time_seconds", "gauge", "Start time of the running process since the epoch.", func(md *Metadata) (float64, bool) {
+		if !md.Alive || md.Uptime <= 0 {
+			return 0, false
+		}
+		return float64(time.Now().Add(-md.Uptime).Unix()), true
+	})
+	family("managed_process_cpu_seconds_total", "counter", "Cpu time used by the running process so far, without its descendants.", func(md *Metadata) (float64, bool) {
+		if md.Stats == nil {
+			return 0, false
+		}
+		return md.Stats.OwnCPUTime.Seconds(), true
+	})
+	// summed over descendants the cpu time drops when one exits, which a
+	// counter must not.
+	family("managed_process_tree_cpu_seconds", "gauge", "Cpu time used by the running process and its sampled descendants so far.", func(md *Metadata) (float64, bool) {
+		if md.Stats == nil {
+			return 0, false
+		}
+		return md.Stats.CPUTime.Seconds(), true
+	})
+	family("managed_process_memory_rss_bytes", "gauge", "Resident memory of the running process.", func(md *Metadata) (float64, bool) {
+		if md.Stats == nil {
+			return 0, false
+		}
+		return float64(md.Stats.RSS), true
+	})
+	family("managed_process_cron_runs_total", "counter", "Runs started by the cron schedule.", func(md *Metadata) (float64, bool) {
+		return float64(md.CronRuns), md.Cron != ""
+	})
+	family("managed_process_cron_failures_total", "counter", "Runs of the cron schedule that failed to start or exited with a failure.", func(md *Metadata) (float64, bool) {
+		return float64(md.CronFailures), md.Cron != ""
+	})
+
+	fmt.Fprintf(w, "# HELP managed_process_health_status Health of the process, 1 for the current status.\n# TYPE managed_process_health_status gauge\n")
+	for _, md := range metadata {
+		if md.HealthCheck == nil {
+			continue
+		}
+		for _, status := range []string{healthStarting, healthHealthy, healthUnhealthy} {
+			fmt.Fprintf(w, "managed_process_health_status{%s} %g\n", metricLabels(md, map[string]string{"status": status}), metricBool(md.Health == status))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP managed_process_recycles_total Graceful restarts for passing max_memory_restart or max_uptime.\n# TYPE managed_process_recycles_total counter\n")
+	for _, md := range metadata {
+		if md.MaxMemoryRestart <= 0 && md.MaxUptime <= 0 {
+			continue
+		}
+		for _, reason := range []string{RecycleMemory, RecycleUptime} {
+			fmt.Fprintf(w, "managed_process_recycles_total{%s} %d\n", metricLabels(md, map[string]string{"reason": reason}), md.Recycles[reason])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP process_manager_processes Processes known to the manager.\n# TYPE process_manager_processes gauge\n")
+	fmt.Fprintf(w, "process_manager_processes %d\n", len(metadata))
+
+	fmt.Fprintf(w, "# HELP process_manager_operate_queue_depth Operations waiting for the operate loop.\n# TYPE process_manager_operate_queue_depth gauge\n")
+	fmt.Fprintf(w, "process_manager_operate_queue_depth %d\n", len(m.operateChannel))
+
+	m.metrics.lock.Lock()
+	defer m.metrics.lock.Unlock()
+
+	operations := make([]string, 0, len(m.metrics.operate))
+	for operation := range m.metrics.operate {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	fmt.Fprintf(w, "# HELP process_manager_operate_duration_seconds Latency of operations, from queueing to the outcome.\n# TYPE process_manager_operate_duration_seconds histogram\n")
+	for _, operation := range operations {
+		h := m.metrics.operate[operation]
+		for i, bound := range operateBuckets {
+			fmt.Fprintf(w, "process_manager_operate_duration_seconds_bucket{operation=%q,le=\"%g\"} %d\n", operation, bound, h.buckets[i])
+		}
+		fmt.Fprintf(w, "process_manager_operate_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", operation, h.count)
+		fmt.Fprintf(w, "process_manager_operate_duration_seconds_sum{operation=%q} %g\n", operation, h.sum)
+		fmt.Fprintf(w, "process_manager_operate_duration_seconds_count{operation=%q} %d\n", operation, h.count)
+	}
+
+	fmt.Fprintf(w, "# HELP process_manager_operate_errors_total Operations that returned an error.\n# TYPE process_manager_operate_errors_total counter\n")
+	for _, operation := range operations {
+		fmt.Fprintf(w, "process_manager_operate_errors_total{operation=%q} %d\n", operation, m.metrics.errors[operation])
+	}
+}
+
+// metricLabels renders the uuid, name and labels of a process as metric
+// labels, process labels get a label_ prefix and any character Prometheus
+// does not allow in a label name becomes an underscore. Of labels that end up
+// with the same name the first in order is kept.
+func metricLabels(md *Metadata, extra map[string]string) string {
+	pairs := []string{
+		"uuid=" + metricValue(md.UUID),
+		"name=" + metricValue(md.Name),
+	}
+
+	keys := make([]string, 0, len(md.Labels))
+	for key := range md.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		name := metricName("label_" + key)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		pairs = append(pairs, name+"="+metricValue(md.Labels[key]))
+	}
+
+	for key, value := range extra {
+		pairs = append(pairs, key+"="+metricValue(value))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func metricName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func metricValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func metricBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,238 @@
+package process
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	rawMessageType    = reflect.TypeOf(json.RawMessage(nil))
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// OpenAPI returns the OpenAPI 3 document of the REST API, generated from its
+// routes and the json encoding of their arguments and replies.
+func OpenAPI() map[string]interface{} {
+	schemas := openAPISchemas{}
+	paths := map[string]interface{}{}
+
+	for _, route := range restRoutes {
+		operation := map[string]interface{}{
+			"operationId": route.operationID,
+			"summary":     route.summary,
+		}
+
+		var parameters []interface{}
+		if strings.Contains(route.path, "{id}") {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        "id",
+				"in":          "path",
+				"required":    true,
+				"description": "uuid or name of the process",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, param := range route.query {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        param.name,
+				"in":          "query",
+				"description": param.description,
+				"schema":      paramSchema(param.kind),
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if route.argv != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": schemas.of(reflect.TypeOf(route.argv)),
+					},
+				},
+			}
+		}
+
+		content := map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schemas.of(reflect.TypeOf(route.reply)),
+			},
+		}
+		if route.stream != nil {
+			content["application/x-ndjson"] = map[string]interface{}{
+				"schema": schemas.of(reflect.TypeOf(route.stream)),
+			}
+		}
+
+		responses := map[string]interface{}{
+			strconv.Itoa(route.status): map[string]interface{}{
+				"description": http.StatusText(route.status),
+				"content":     content,
+			},
+			"default": map[string]interface{}{
+				"description": "error: 400 invalid request, 401 unauthenticated, 403 permission denied, 404 process not found, 409 conflict with the state of the process, 422 operation failed, 504 timeout",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": schemas.of(reflect.TypeOf(restError{})),
+					},
+				},
+			},
+		}
+		operation["responses"] = responses
+
+		if route.public {
+			operation["security"] = []interface{}{}
+		}
+
+		item, ok := paths[route.path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[route.path] = item
+		}
+		item[strings.ToLower(route.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "process",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}(schemas),
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+			},
+		},
+		// a token is only needed when the service has tokens.
+		"security": []interface{}{
+			map[string]interface{}{"bearer": []interface{}{}},
+			map[string]interface{}{},
+		},
+	}
+}
+
+func paramSchema(kind string) map[string]interface{} {
+	switch kind {
+	case "duration":
+		return map[string]interface{}{"type": "string", "example": "5s"}
+	case "date-time":
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	default:
+		return map[string]interface{}{"type": kind}
+	}
+}
+
+// openAPISchemas collects the schemas of the named structs, which the others
+// refer to.
+type openAPISchemas map[string]interface{}
+
+func (s openAPISchemas) of(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+
+		name := strings.TrimPrefix(t.Name(), "rest")
+		name = strings.ToUpper(name[:1]) + name[1:]
+
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, ok := s[name]; !ok {
+			// set before the fields, a struct may refer to itself.
+			s[name] = map[string]interface{}{}
+			s[name] = s.object(t)
+		}
+		return ref
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// object returns the schema of a struct, its fields as encoding/json names
+// them, those of embedded structs among them.
+func (s openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			name := field.Name
+			if tag := field.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if j := strings.Index(tag, ","); j >= 0 {
+					tag = tag[:j]
+				}
+				if tag != "" {
+					name = tag
+				}
+			}
+
+			ft := field.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if field.Anonymous && field.Tag.Get("json") == "" && ft.Kind() == reflect.Struct {
+				walk(ft)
+				continue
+			}
+			if field.PkgPath != "" {
+				continue
+			}
+
+			properties[name] = s.of(field.Type)
+		}
+	}
+	walk(t)
+
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
@@ -1,78 +1,181 @@
 package process
 
 import (
+	"context"
 	"syscall"
 	"time"
 )
 
+// request carries an operation through the operate channel, the outcome is
+// sent back on reply. queued is when it was sent, for the latency metrics.
+type request struct {
+	ctx     context.Context
+	operate interface{}
+	reply   chan *response
+	queued  time.Time
+}
+
+type response struct {
+	outcome *Outcome
+	err     error
+}
+
+// Outcome is the result of an operation, the process it acted on and, for an
+// apply, the changes made.
+type Outcome struct {
+	UUID    string
+	Name    string
+	Pid     int
+	Changes []*Change
+}
+
 type OperateStart struct {
-	Dir     string
-	Cmd     string
-	Argv    []string
-	Env     []string
-	Files   []string
-	Restart bool
-	Cron    string
-}
-
-func newOperateStart(dir, cmd string, argv, env, files []string, restart bool, cron string) *OperateStart {
-	return &OperateStart{
-		Dir:     dir,
-		Cmd:     cmd,
-		Argv:    argv,
-		Env:     env,
-		Files:   files,
-		Restart: restart,
-		Cron:    cron,
+	Name     string
+	Dir      string
+	Cmd      string
+	Argv     []string
+	Env      []string
+	Files    []string
+	Restart  bool
+	Cron     string
+	Labels   map[string]string
+	Capture  bool
+	Rotation *Rotation
+
+	RestartPolicy *RestartPolicy
+	HealthCheck   *HealthCheck
+	Readiness     *Readiness
+	DependsOn     []*Dependency
+	KillMode      string
+	Session       bool
+	User          string
+	Group         string
+	Groups        []string
+	Limits        []*Rlimit
+	Cgroup        *Cgroup
+
+	MaxMemoryRestart Size
+	MaxUptime        time.Duration
+}
+
+// attributes are those of the process the start creates, in one place so a
+// new field is not left out.
+func (o *OperateStart) attributes() *Attributes {
+	return &Attributes{
+		name:     o.Name,
+		dir:      o.Dir,
+		cmd:      o.Cmd,
+		argv:     o.Argv,
+		env:      o.Env,
+		files:    o.Files,
+		restart:  o.Restart,
+		cron:     o.Cron,
+		labels:   o.Labels,
+		capture:  o.Capture,
+		rotation: o.Rotation,
+
+		restartPolicy: o.RestartPolicy,
+		healthCheck:   o.HealthCheck,
+		readiness:     o.Readiness,
+		dependsOn:     o.DependsOn,
+		kill:          o.KillMode,
+		session:       o.Session,
+		user:          o.User,
+		group:         o.Group,
+		groups:        o.Groups,
+		limits:        o.Limits,
+		cgroup:        o.Cgroup,
+
+		maxMemoryRestart: o.MaxMemoryRestart,
+		maxUptime:        o.MaxUptime,
 	}
 }
 
 type OperateKill struct {
-	UUID  string
+	ID    string
 	Prune bool
 }
 
-func newOperateKill(uuid string, prune bool) *OperateKill {
+func newOperateKill(id string, prune bool) *OperateKill {
 	return &OperateKill{
-		UUID:  uuid,
+		ID:    id,
 		Prune: prune,
 	}
 }
 
 type OperateStop struct {
-	UUID       string
+	ID         string
 	Gracefully time.Duration
 	Prune      bool
 }
 
-func newOperateStop(uuid string, gracefully time.Duration, prune bool) *OperateStop {
+func newOperateStop(id string, gracefully time.Duration, prune bool) *OperateStop {
 	return &OperateStop{
-		UUID:       uuid,
+		ID:         id,
 		Gracefully: gracefully,
 		Prune:      prune,
 	}
 }
 
 type OperateRestart struct {
-	UUID       string
+	ID         string
 	Gracefully time.Duration
+	Cascade    bool
 }
 
-func newOperateRestart(uuid string, gracefully time.Duration) *OperateRestart {
+func newOperateRestart(id string, gracefully time.Duration, cascade bool) *OperateRestart {
 	return &OperateRestart{
-		UUID:       uuid,
+		ID:         id,
 		Gracefully: gracefully,
+		Cascade:    cascade,
 	}
 }
 
 type OperateSignal struct {
-	UUID   string
+	ID     string
 	Signal syscall.Signal
 }
 
-func newOperateSignal(uuid string, signal syscall.Signal) *OperateSignal {
+func newOperateSignal(id string, signal syscall.Signal) *OperateSignal {
 	return &OperateSignal{
-		UUID:   uuid,
+		ID:     id,
 		Signal: signal,
 	}
 }
+
+// operateRetry starts the given run of a process again, it is queued by the
+// restart policy rather than by a client.
+type operateRetry struct {
+	id  string
+	run uint64
+}
+
+// operateStep runs fn in the operate loop for an operation that waits off it.
+type operateStep struct {
+	fn func() error
+}
+
+type operateLaunch struct {
+	id      string
+	pending chan struct{}
+}
+
+type OperateApply struct {
+	Source     string
+	Processes  []*EcosystemProcess
+	Delete     bool
+	DryRun     bool
+	Force      bool
+	Gracefully time.Duration
+}
+
+func newOperateApply(source string, processes []*EcosystemProcess, delete, dryRun, force bool, gracefully time.Duration) *OperateApply {
+	return &OperateApply{
+		Source:     source,
+		Processes:  processes,
+		Delete:     delete,
+		DryRun:     dryRun,
+		Force:      force,
+		Gracefully: gracefully,
+	}
+}
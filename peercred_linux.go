@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredential returns the user of the process on the other end of a unix
+// socket, from SO_PEERCRED.
+func peerCredential(conn *net.UnixConn) (*PeerCredential, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *unix.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+
+	return &PeerCredential{
+		PID: int(ucred.Pid),
+		UID: int(ucred.Uid),
+		GID: int(ucred.Gid),
+	}, nil
+}
@@ -0,0 +1,305 @@
+package process
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var errPermissionDenied = errors.New("permission denied")
+
+// Policy grants callers verbs over processes. Bindings give the roles to
+// subjects: cn:name for a client certificate, token:name for a token of
+// Tokens, uid:id or user:name and gid:id or group:name for unix socket peers.
+// Tokens maps token names to the hex SHA-256 of the token.
+type Policy struct {
+	Tokens   map[string]string   `json:"tokens" yaml:"tokens" toml:"tokens"`
+	Roles    map[string][]*Grant `json:"roles" yaml:"roles" toml:"roles"`
+	Bindings []*Binding          `json:"bindings" yaml:"bindings" toml:"bindings"`
+}
+
+// Grant allows verbs, the rpc operations or * for all, on the processes
+// matching the selector or one of the names, which may be patterns such as
+// web-*. A grant without selector and names covers every process.
+//
+// Starting a process, by start or apply, also needs its command among
+// Commands, absolute path patterns such as /usr/bin/*, and the user and the
+// groups it runs as among Users and Groups, names or ids; * allows any. A
+// process without a user runs as the user of the service.
+type Grant struct {
+	Verbs    []string `json:"verbs" yaml:"verbs" toml:"verbs"`
+	Selector string   `json:"selector" yaml:"selector" toml:"selector"`
+	Names    []string `json:"names" yaml:"names" toml:"names"`
+	Commands []string `json:"commands" yaml:"commands" toml:"commands"`
+	Users    []string `json:"users" yaml:"users" toml:"users"`
+	Groups   []string `json:"groups" yaml:"groups" toml:"groups"`
+
+	selector Selector
+}
+
+type Binding struct {
+	Role     string   `json:"role" yaml:"role" toml:"role"`
+	Subjects []string `json:"subjects" yaml:"subjects" toml:"subjects"`
+}
+
+// LoadPolicy reads a policy file, the format is chosen by extension: .yaml,
+// .yml, .toml or .json.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, p)
+	case ".toml":
+		err = toml.Unmarshal(data, p)
+	case ".json":
+		err = json.Unmarshal(data, p)
+	default:
+		return nil, fmt.Errorf("unsupported policy format: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+
+	return p, p.validate()
+}
+
+// validate checks the policy and resolves the user and group subjects to
+// ids, which is what unix socket peers are known by.
+func (p *Policy) validate() error {
+	for name, digest := range p.Tokens {
+		b, err := hex.DecodeString(digest)
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("token %s: want the hex sha256 of the token", name)
+		}
+	}
+
+	for role, grants := range p.Roles {
+		for _, g := range grants {
+			for _, verb := range g.Verbs {
+				if verb != "*" && !containsString(operations, verb) {
+					return fmt.Errorf("role %s: unknown verb %s", role, verb)
+				}
+			}
+
+			for _, name := range g.Names {
+				if _, err := path.Match(name, ""); err != nil {
+					return fmt.Errorf("role %s: invalid name pattern %s", role, name)
+				}
+			}
+
+			for _, command := range g.Commands {
+				if _, err := path.Match(command, ""); err != nil || command != "*" && !path.IsAbs(command) {
+					return fmt.Errorf("role %s: invalid command pattern %s, want an absolute path or *", role, command)
+				}
+			}
+
+			for i, name := range g.Users {
+				if name == "*" {
+					continue
+				}
+				u, err := lookupUser(name)
+				if err != nil {
+					return fmt.Errorf("role %s: %w", role, err)
+				}
+				g.Users[i] = u.Uid
+			}
+
+			for i, name := range g.Groups {
+				if name == "*" {
+					continue
+				}
+				gid, err := lookupGroup(name)
+				if err != nil {
+					return fmt.Errorf("role %s: %w", role, err)
+				}
+				g.Groups[i] = strconv.FormatUint(uint64(gid), 10)
+			}
+
+			s, err := ParseSelector(g.Selector)
+			if err != nil {
+				return fmt.Errorf("role %s: %w", role, err)
+			}
+			g.selector = s
+		}
+	}
+
+	for _, b := range p.Bindings {
+		if _, ok := p.Roles[b.Role]; !ok {
+			return fmt.Errorf("binding of unknown role %s", b.Role)
+		}
+
+		for i, subject := range b.Subjects {
+			kind, name := subject, ""
+			if j := strings.Index(subject, ":"); j >= 0 {
+				kind, name = subject[:j], subject[j+1:]
+			}
+
+			switch kind {
+			case "cn", "token":
+			case "uid", "user":
+				u, err := lookupUser(name)
+				if err != nil {
+					return fmt.Errorf("binding of role %s: %w", b.Role, err)
+				}
+				b.Subjects[i] = "uid:" + u.Uid
+			case "gid", "group":
+				gid, err := lookupGroup(name)
+				if err != nil {
+					return fmt.Errorf("binding of role %s: %w", b.Role, err)
+				}
+				b.Subjects[i] = "gid:" + strconv.FormatUint(uint64(gid), 10)
+			default:
+				return fmt.Errorf("binding of role %s: invalid subject %s, want cn:, token:, uid:, user:, gid: or group:", b.Role, subject)
+			}
+
+			if name == "" {
+				return fmt.Errorf("binding of role %s: invalid subject %s", b.Role, subject)
+			}
+		}
+	}
+
+	return nil
+}
+
+// token returns the name of the token, empty when the policy has no such
+// token.
+func (p *Policy) token(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	digest := hex.EncodeToString(sum[:])
+
+	found := ""
+	for name, d := range p.Tokens {
+		if subtle.ConstantTimeCompare([]byte(strings.ToLower(d)), []byte(digest)) == 1 {
+			found = name
+		}
+	}
+	return found
+}
+
+func (p *Policy) grants(subjects []string, verb string) []*Grant {
+	var grants []*Grant
+
+	for _, b := range p.Bindings {
+		bound := false
+		for _, subject := range b.Subjects {
+			if containsString(subjects, subject) {
+				bound = true
+				break
+			}
+		}
+		if !bound {
+			continue
+		}
+
+		for _, g := range p.Roles[b.Role] {
+			if containsString(g.Verbs, "*") || containsString(g.Verbs, verb) {
+				grants = append(grants, g)
+			}
+		}
+	}
+
+	return grants
+}
+
+func (p *Policy) unrestricted(subjects []string, verb string) bool {
+	for _, g := range p.grants(subjects, verb) {
+		if g.Selector == "" && len(g.Names) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Grant) matches(name string, labels map[string]string) bool {
+	if g.Selector == "" && len(g.Names) == 0 {
+		return true
+	}
+
+	if g.Selector != "" && g.selector.Matches(labels) {
+		return true
+	}
+
+	for _, pattern := range g.Names {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runs tells whether the grant allows running cmd with env as uid and gids.
+// Unless any command is allowed, the loader variables are refused as well,
+// they would run other code inside an allowed command.
+func (g *Grant) runs(cmd string, env []string, uid string, gids []string) bool {
+	if !containsString(g.Commands, "*") {
+		allowed := false
+		for _, pattern := range g.Commands {
+			if ok, _ := path.Match(pattern, path.Clean(cmd)); ok && path.IsAbs(cmd) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+
+		for _, e := range env {
+			if strings.HasPrefix(e, "LD_") || strings.HasPrefix(e, "DYLD_") {
+				return false
+			}
+		}
+	}
+
+	if !containsString(g.Users, "*") && !containsString(g.Users, uid) {
+		return false
+	}
+
+	if !containsString(g.Groups, "*") {
+		for _, gid := range gids {
+			if !containsString(g.Groups, gid) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (p *Peer) subjects() []string {
+	var subjects []string
+
+	if p.CommonName != "" {
+		subjects = append(subjects, "cn:"+p.CommonName)
+	}
+
+	if p.Token != "" {
+		subjects = append(subjects, "token:"+p.Token)
+	}
+
+	if p.Credential != nil {
+		subjects = append(subjects, "uid:"+strconv.Itoa(p.Credential.UID))
+		for _, gid := range peerGroups(p.Credential) {
+			subjects = append(subjects, "gid:"+strconv.Itoa(gid))
+		}
+	}
+
+	return subjects
+}
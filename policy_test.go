@@ -0,0 +1,146 @@
+package process
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestGrantRuns(t *testing.T) {
+	restricted := &Grant{
+		Commands: []string{"/usr/bin/*", "/opt/app/bin/server"},
+		Users:    []string{"1000"},
+		Groups:   []string{"100"},
+	}
+	unrestricted := &Grant{Commands: []string{"*"}, Users: []string{"*"}, Groups: []string{"*"}}
+
+	tests := []struct {
+		name  string
+		grant *Grant
+		cmd   string
+		env   []string
+		uid   string
+		gids  []string
+		want  bool
+	}{
+		{"allowed", restricted, "/usr/bin/python3", nil, "1000", nil, true},
+		{"exact command", restricted, "/opt/app/bin/server", []string{"PORT=80"}, "1000", []string{"100"}, true},
+		{"other command", restricted, "/bin/sh", nil, "1000", nil, false},
+		{"escaping the pattern", restricted, "/usr/bin/../../bin/sh", nil, "1000", nil, false},
+		{"relative command", restricted, "usr/bin/python3", nil, "1000", nil, false},
+		{"loader variable", restricted, "/usr/bin/python3", []string{"LD_PRELOAD=/tmp/x.so"}, "1000", nil, false},
+		{"darwin loader variable", restricted, "/usr/bin/python3", []string{"DYLD_INSERT_LIBRARIES=/tmp/x.dylib"}, "1000", nil, false},
+		{"other user", restricted, "/usr/bin/python3", nil, "0", nil, false},
+		{"other group", restricted, "/usr/bin/python3", nil, "1000", []string{"100", "0"}, false},
+		{"unrestricted", unrestricted, "bin/sh", []string{"LD_PRELOAD=/tmp/x.so"}, "0", []string{"0"}, true},
+	}
+
+	for _, test := range tests {
+		if got := test.grant.runs(test.cmd, test.env, test.uid, test.gids); got != test.want {
+			t.Errorf("%s: runs = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAuthorizeRun(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	policy := &Policy{
+		Tokens: map[string]string{"ci": hex.EncodeToString(sum[:])},
+		Roles: map[string][]*Grant{"deployer": {{
+			Verbs:    []string{"start"},
+			Names:    []string{"web-*"},
+			Commands: []string{"/usr/bin/*"},
+			Users:    []string{strconv.Itoa(os.Getuid())},
+		}}},
+		Bindings: []*Binding{
+			{Role: "deployer", Subjects: []string{"token:ci"}},
+		},
+	}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	withPolicy := NewRPC(NewManager(), WithPolicy(policy))
+	withoutPolicy := NewRPC(NewManager())
+
+	token := &Peer{Address: "127.0.0.1:1234", Token: "ci"}
+	unix := &Peer{Credential: &PeerCredential{PID: 1, UID: 12345, GID: 12345}}
+	root := &Peer{Credential: &PeerCredential{PID: 1, UID: 0, GID: 0}}
+
+	tests := []struct {
+		name    string
+		service *RPC
+		peer    *Peer
+		process string
+		cmd     string
+		user    string
+		allowed bool
+	}{
+		{"granted", withPolicy, token, "web-1", "/usr/bin/python3", "", true},
+		{"other name", withPolicy, token, "api", "/usr/bin/python3", "", false},
+		{"other command", withPolicy, token, "web-1", "/bin/sh", "", false},
+		{"other user", withPolicy, token, "web-1", "/usr/bin/python3", "12345", false},
+		{"no binding", withPolicy, &Peer{Address: "127.0.0.1:1234"}, "web-1", "/usr/bin/python3", "", false},
+		{"root", withPolicy, root, "api", "/bin/sh", "12345", true},
+		{"own user", withoutPolicy, unix, "api", "/bin/sh", "12345", true},
+		{"user of the service", withoutPolicy, unix, "api", "/bin/sh", "", false},
+		{"tcp caller", withoutPolicy, &Peer{Address: "127.0.0.1:1234"}, "api", "/bin/sh", "", true},
+	}
+
+	for _, test := range tests {
+		r := test.service.session(test.peer)
+
+		err := r.authorizeRun("start", test.process, nil, test.cmd, nil, nil, test.user, "", nil)
+		if test.allowed && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if !test.allowed && !errors.Is(err, errPermissionDenied) {
+			t.Errorf("%s: got %v, want permission denied", test.name, err)
+		}
+	}
+}
+
+func TestAuthorizeRunExecHealthCheck(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	policy := &Policy{
+		Tokens: map[string]string{"ci": hex.EncodeToString(sum[:])},
+		Roles: map[string][]*Grant{"deployer": {{
+			Verbs:    []string{"start"},
+			Commands: []string{"/usr/bin/*"},
+			Users:    []string{strconv.Itoa(os.Getuid())},
+		}}},
+		Bindings: []*Binding{
+			{Role: "deployer", Subjects: []string{"token:ci"}},
+		},
+	}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRPC(NewManager(), WithPolicy(policy)).session(&Peer{Address: "127.0.0.1:1234", Token: "ci"})
+
+	tests := []struct {
+		name    string
+		check   *HealthCheck
+		allowed bool
+	}{
+		{"no check", nil, true},
+		{"tcp check", &HealthCheck{Type: HealthTCP, Address: "127.0.0.1:80"}, true},
+		{"allowed exec", &HealthCheck{Type: HealthExec, Exec: []string{"/usr/bin/curl", "-f", "http://127.0.0.1"}}, true},
+		{"other exec", &HealthCheck{Type: HealthExec, Exec: []string{"/bin/sh", "-c", "true"}}, false},
+		{"relative exec", &HealthCheck{Type: HealthExec, Exec: []string{"curl"}}, false},
+	}
+
+	for _, test := range tests {
+		err := r.authorizeRun("start", "web", nil, "/usr/bin/python3", test.check, nil, "", "", nil)
+		if test.allowed && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if !test.allowed && !errors.Is(err, errPermissionDenied) {
+			t.Errorf("%s: got %v, want permission denied", test.name, err)
+		}
+	}
+}
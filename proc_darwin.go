@@ -0,0 +1,46 @@
+//go:build darwin
+// +build darwin
+
+package process
+
+import (
+	"errors"
+	"time"
+)
+
+var errProcUnsupported = errors.New("proc filesystem not supported")
+
+type procStat struct {
+	pid       int
+	state     byte
+	ppid      int
+	pgrp      int
+	utime     uint64
+	stime     uint64
+	threads   int
+	startTime uint64
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	return nil, errProcUnsupported
+}
+
+func procStartTime(pid int) (uint64, error) {
+	return 0, errProcUnsupported
+}
+
+func readProcSample(pid int) (*procSample, error) {
+	return nil, errProcUnsupported
+}
+
+func procStarted(startTime uint64) (time.Time, error) {
+	return time.Time{}, errProcUnsupported
+}
+
+func listPids() ([]int, error) {
+	return nil, errProcUnsupported
+}
+
+func descendants(pid int) ([]*procStat, error) {
+	return nil, errProcUnsupported
+}
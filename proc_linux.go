@@ -0,0 +1,236 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type procStat struct {
+	pid       int
+	state     byte
+	ppid      int
+	pgrp      int
+	utime     uint64
+	stime     uint64
+	threads   int
+	startTime uint64
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// comm may contain spaces and parentheses, fields start after the last ')'.
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 || i+2 >= len(data) {
+		return nil, fmt.Errorf("malformed stat: %d", pid)
+	}
+
+	fields := bytes.Fields(data[i+2:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("malformed stat: %d", pid)
+	}
+
+	s := &procStat{
+		pid:   pid,
+		state: fields[0][0],
+	}
+
+	s.ppid, err = strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	s.pgrp, err = strconv.Atoi(string(fields[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	s.utime, err = strconv.ParseUint(string(fields[11]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	s.stime, err = strconv.ParseUint(string(fields[12]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	s.threads, err = strconv.Atoi(string(fields[17]))
+	if err != nil {
+		return nil, err
+	}
+
+	s.startTime, err = strconv.ParseUint(string(fields[19]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// readProcSample reads the resource usage of a process from /proc/<pid>/stat,
+// status, io and fd. io is only readable with the rights to ptrace the
+// process, without them the byte counts stay zero.
+func readProcSample(pid int) (*procSample, error) {
+	s, err := readProcStat(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.state == 'Z' || s.state == 'X' {
+		return nil, fmt.Errorf("process exited: %d", pid)
+	}
+
+	sample := &procSample{
+		cpuTicks: s.utime + s.stime,
+		threads:  s.threads,
+	}
+
+	status := readProcKeyed(fmt.Sprintf("/proc/%d/status", pid), ":")
+	if rss, ok := status["VmRSS"]; ok {
+		// VmRSS is given in kB.
+		sample.rss = rss * 1024
+	}
+
+	io := readProcKeyed(fmt.Sprintf("/proc/%d/io", pid), ":")
+	sample.readBytes = io["read_bytes"]
+	sample.writeBytes = io["write_bytes"]
+
+	d, err := os.Open(fmt.Sprintf("/proc/%d/fd", pid))
+	if err == nil {
+		names, _ := d.Readdirnames(-1)
+		sample.fds = len(names)
+		_ = d.Close()
+	}
+
+	return sample, nil
+}
+
+func readProcKeyed(path, sep string) map[string]uint64 {
+	values := make(map[string]uint64)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return values
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		i := strings.Index(line, sep)
+		if i < 0 {
+			continue
+		}
+
+		fields := strings.Fields(line[i+len(sep):])
+		if len(fields) == 0 {
+			continue
+		}
+
+		n, err := strconv.ParseUint(fields[0], 10, 64)
+		if err == nil {
+			values[line[:i]] = n
+		}
+	}
+
+	return values
+}
+
+var (
+	bootOnce sync.Once
+	boot     time.Time
+	bootErr  error
+)
+
+func procStarted(startTime uint64) (time.Time, error) {
+	bootOnce.Do(func() {
+		btime, ok := readProcKeyed("/proc/stat", " ")["btime"]
+		if !ok {
+			bootErr = fmt.Errorf("boot time not found")
+			return
+		}
+		boot = time.Unix(int64(btime), 0)
+	})
+	if bootErr != nil {
+		return time.Time{}, bootErr
+	}
+
+	return boot.Add(time.Duration(startTime) * time.Second / clockTicks), nil
+}
+
+// procStartTime returns the start time of a live process in clock ticks since
+// boot, together with the pid it identifies a process instance.
+func procStartTime(pid int) (uint64, error) {
+	s, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.state == 'Z' || s.state == 'X' {
+		return 0, fmt.Errorf("process exited: %d", pid)
+	}
+
+	return s.startTime, nil
+}
+
+func listPids() ([]int, error) {
+	d, err := os.Open("/proc")
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]int, 0, len(names))
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func descendants(pid int) ([]*procStat, error) {
+	pids, err := listPids()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int][]*procStat)
+	for _, p := range pids {
+		s, err := readProcStat(p)
+		if err != nil {
+			continue
+		}
+		children[s.ppid] = append(children[s.ppid], s)
+	}
+
+	var found []*procStat
+
+	queue := []int{pid}
+	for len(queue) > 0 {
+		for _, s := range children[queue[0]] {
+			found = append(found, s)
+			queue = append(queue, s.pid)
+		}
+		queue = queue[1:]
+	}
+
+	return found, nil
+}
@@ -2,21 +2,115 @@ package process
 
 import (
 	"container/ring"
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/robfig/cron/v3"
 )
 
 type Attributes struct {
-	dir     string
-	cmd     string
-	argv    []string
-	env     []string
-	files   []string
-	restart bool
-	cron    string
+	name     string
+	source   string
+	dir      string
+	cmd      string
+	argv     []string
+	env      []string
+	files    []string
+	restart  bool
+	cron     string
+	labels   map[string]string
+	capture  bool
+	rotation *Rotation
+
+	restartPolicy *RestartPolicy
+	healthCheck   *HealthCheck
+	readiness     *Readiness
+	dependsOn     []*Dependency
+	kill          string
+	session       bool
+	user          string
+	group         string
+	groups        []string
+	limits        []*Rlimit
+	cgroup        *Cgroup
+
+	maxMemoryRestart Size
+	maxUptime        time.Duration
+}
+
+func (a *Attributes) validate() error {
+	err := validateLabels(a.labels)
+	if err != nil {
+		return err
+	}
+
+	if a.rotation != nil {
+		if !a.capture {
+			return fmt.Errorf("log rotation requires captured output")
+		}
+
+		err = a.rotation.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.restartPolicy != nil {
+		err = a.restartPolicy.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.healthCheck != nil {
+		err = a.healthCheck.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.readiness != nil {
+		err = a.readiness.validate(a.capture)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, d := range a.dependsOn {
+		err = d.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validateKillMode(a.kill)
+	if err != nil {
+		return err
+	}
+
+	err = validateRlimits(a.limits)
+	if err != nil {
+		return err
+	}
+
+	if a.cgroup != nil {
+		err = a.cgroup.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.maxMemoryRestart < 0 || a.maxUptime < 0 {
+		return fmt.Errorf("invalid recycle limits: max_memory_restart=%s max_uptime=%s", a.maxMemoryRestart, a.maxUptime)
+	}
+
+	return nil
 }
 
 type Process struct {
@@ -28,13 +122,77 @@ type Process struct {
 	processState *os.ProcessState
 	files        []*os.File
 	events       *ring.Ring
+	startTime    uint64
+	pgid         int
+	adopted      bool
+	gone         bool
+	done         chan struct{}
+	cronEntry    cron.EntryID
+	logs         *logBuffer
+	sinks        []*rotateWriter
+
+	// status is the lifecycle state, see lifecycle.go, entered at since.
+	// exit is the state the last run ended in, exited or failed.
+	status string
+	since  time.Time
+	exit   string
+
+	health         string
+	healthFailures int
+	healthRestarts int
+
+	// ready tells the current run passed its readiness, see readiness.go.
+	// change is closed and replaced on every change of status or readiness.
+	ready  bool
+	change chan struct{}
+
+	// pending is closed to give up waiting for the dependencies, the wait
+	// started in waitingFrom, see depends.go.
+	pending     chan struct{}
+	waitingFrom string
+
+	// restart bookkeeping, see restart.go. runs counts the starts, stopped
+	// marks an explicit stop of the current run.
+	runs         uint64
+	startedAt    time.Time
+	stopped      bool
+	restarts     int
+	failures     int
+	restartTimes []time.Time
+	nextRetry    time.Time
+	retry        *time.Timer
+
+	// stats is the last resource usage sample of the current run, see
+	// stats.go, taken when the cpu time of the run was sampleTicks.
+	stats       *Stats
+	sampleTicks uint64
+	sampleRun   uint64
+
+	// cronRuns counts the runs the cron schedule started, cronFailures those
+	// that failed to start or exited with a failure.
+	cronRuns     int
+	cronFailures int
+
+	// recycles counts the restarts for max_memory_restart and max_uptime by
+	// reason, the last at recycledAt, see recycle.go.
+	recycles   map[string]int
+	recycledAt time.Time
 }
 
-func (p *Process) isRunning() bool {
+func (p *Process) definition() *definition {
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	return p.process != nil && p.processState == nil
+	d := newDefinition(p.uuid, p.attributes)
+
+	if p.status == statusRunning || p.status == statusStopping {
+		d.Pid = p.process.Pid
+		d.StartTime = p.startTime
+	}
+
+	d.Stopped = p.stopped
+
+	return d
 }
 
 func (p *Process) pushEvent(kind kind, message string) {
@@ -65,54 +223,141 @@ func (p *Process) closeFiles(files ...*os.File) {
 	}
 }
 
-func (p *Process) start() {
+func (p *Process) start() error {
 	p.m.Lock()
 	defer p.m.Unlock()
 
+	err := p.transition(statusStarting)
+	if err != nil {
+		return err
+	}
+
+	id, err := p.attributes.identity()
+	if err != nil {
+		p.failed()
+		p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, resolve user failed: %s", p.uuid, err))
+		return err
+	}
+
+	cgroup := p.cgroup()
+	if cgroup != "" {
+		err = setupCgroup(cgroup, p.attributes.cgroup)
+		if err != nil {
+			p.failed()
+			p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, setup cgroup failed: %s", p.uuid, err))
+			return err
+		}
+	}
+
 	files, err := p.openFiles(p.attributes.files...)
 	if err != nil {
+		p.failed()
 		p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, open file: %v, failed: %s", p.uuid, p.attributes.files, err))
-		return
+		return err
+	}
+
+	p.pushEvent(eventKindData, fmt.Sprintf("process: %s, open file: %v success", p.uuid, p.attributes.files))
+
+	var (
+		outputs []*output
+		sinks   []*rotateWriter
+	)
+	if p.attributes.capture {
+		var child []*os.File
+		child, outputs, sinks, err = openOutputs(files, p.attributes.files, p.attributes.rotation)
+		if err != nil {
+			p.closeFiles(files...)
+			p.failed()
+			p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, open pipe failed: %s", p.uuid, err))
+			return err
+		}
+
+		files = child
 	}
 
 	p.files = files
 
-	p.pushEvent(eventKindData, fmt.Sprintf("process: %s, open file: %v success", p.uuid, p.attributes.files))
+	var (
+		env     = id.env(p.attributes.env)
+		notify  *net.UnixConn
+		process *os.Process
+	)
+
+	if p.attributes.readiness != nil && p.attributes.readiness.Type == ReadyNotify {
+		notify, err = p.listenNotify(id)
+		if err == nil {
+			env = notifyEnv(env, notify.LocalAddr().String())
+		}
+	}
+
+	// the drains run as soon as the child does, lines before the cursor are
+	// those of earlier runs.
+	cursor := p.logs.cursor()
+
+	if err == nil {
+		process, err = p.manager.reaper.start(func() (*os.Process, error) {
+			return p.attributes.startProcess(id, cgroup, env, p.files)
+		})
+	}
+
+	// the child holds its own copy of the write ends now.
+	for _, o := range outputs {
+		_ = o.writer.Close()
+	}
 
-	process, err := os.StartProcess(p.attributes.cmd, p.attributes.argv, &os.ProcAttr{
-		Dir:   p.attributes.dir,
-		Env:   p.attributes.env,
-		Files: p.files,
-		Sys:   nil,
-	})
 	if err != nil {
+		if notify != nil {
+			closeNotify(notify)
+		}
+		for _, o := range outputs {
+			_ = o.reader.Close()
+		}
+		for _, sink := range sinks {
+			_ = sink.Close()
+		}
+		p.closeFiles(files...)
+		p.failed()
 		p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, start failed: %s", p.uuid, err))
-		return
+		return err
 	}
 
+	p.sinks = sinks
+
+	go p.drainOutputs(outputs, sinks)
+
 	p.process = process
 	p.processState = nil
+	p.adopted = false
+	p.gone = false
+	p.startTime, _ = procStartTime(process.Pid)
+	p.pgid = process.Pid
+	p.startedAt = time.Now()
+	p.stopped = false
+	p.runs++
+	p.done = make(chan struct{})
+
+	_ = p.transition(statusRunning)
+
+	p.watchHealth()
+	p.watchReadiness(notify, cursor)
 
 	p.pushEvent(eventKindData, fmt.Sprintf("process: %s, start success, pid: %d", p.uuid, process.Pid))
 
-	go func(process *os.Process, files []*os.File) {
-		defer func() {
-			if p.attributes.restart {
-				_ = p.manager.Operate(&OperateRestart{
-					UUID:       p.uuid,
-					Gracefully: time.Second,
-				}, time.Second)
-			}
-		}()
+	go func(process *os.Process, files []*os.File, done chan struct{}) {
+		defer p.exited()
+		defer close(done)
 
 		processState, err := process.Wait()
 
+		p.manager.reaper.release(process.Pid)
+
 		p.m.Lock()
 		defer p.m.Unlock()
 
 		defer p.closeFiles(files...)
 
 		if err != nil {
+			p.failed()
 			p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, wait failed: %s", p.uuid, err))
 			return
 		}
@@ -120,32 +365,186 @@ func (p *Process) start() {
 		p.pushEvent(eventKindData, fmt.Sprintf("process: %s, wait success, pid: %d", p.uuid, process.Pid))
 
 		p.processState = processState
-	}(process, files)
+
+		p.ended(processState.Success())
+	}(process, files, p.done)
+
+	return nil
+}
+
+// adopt attaches to a process left behind by a previous manager. It is not our
+// child, so liveness is polled instead of waited on.
+func (p *Process) adopt(pid int, startTime uint64) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	p.process = process
+	p.processState = nil
+	p.startTime = startTime
+	p.adopted = true
+	p.gone = false
+	p.startedAt = time.Now()
+
+	// only a group of its own is signalled as a whole, a process started
+	// before groups were used shares one with the previous manager.
+	p.pgid = 0
+	if s, err := readProcStat(pid); err == nil && s.pgrp == pid {
+		p.pgid = pid
+	}
+
+	p.runs++
+	p.done = make(chan struct{})
+
+	_ = p.transition(statusRunning)
+
+	p.watchHealth()
+	p.watchReadiness(nil, 0)
+
+	p.pushEvent(eventKindData, fmt.Sprintf("process: %s, adopt success, pid: %d", p.uuid, pid))
+
+	go p.watch(process, startTime, p.done)
+
+	return nil
+}
+
+func (p *Process) watch(process *os.Process, startTime uint64, done chan struct{}) {
+	defer p.exited()
+	defer close(done)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for range t.C {
+		st, err := procStartTime(process.Pid)
+		if err == nil && st == startTime {
+			continue
+		}
+
+		p.m.Lock()
+		p.gone = true
+		p.ended(false)
+		p.pushEvent(eventKindData, fmt.Sprintf("process: %s, adopted process exited, pid: %d", p.uuid, process.Pid))
+		p.m.Unlock()
+
+		return
+	}
+}
+
+func (p *Process) wait(ctx context.Context) error {
+	p.m.Lock()
+	done := p.done
+	p.m.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("process: %s, wait exit: %w", p.uuid, ctx.Err())
+	case <-done:
+		return nil
+	}
+}
+
+// exited hands the end of a run to the restart policy. A run counts as failed
+// when it exited non-zero, was killed by a signal or ended unobserved.
+func (p *Process) exited() {
+	p.manager.persist()
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.scheduleRestart(p.exit == statusFailed)
+
+	if p.cronEntry != 0 && p.exit == statusFailed {
+		p.cronFailures++
+	}
+
+	if p.cronEntry != 0 && (p.status == statusExited || p.status == statusFailed) {
+		_ = p.transition(statusScheduled)
+	}
+}
+
+// ended moves a run that is over to exited or failed, a run ended by a stop
+// exited as asked whatever its status. Called with p.m held.
+func (p *Process) ended(success bool) {
+	p.health = ""
+	p.ready = false
+
+	if success || p.stopped {
+		p.exit = statusExited
+		_ = p.transition(statusExited)
+		return
+	}
+
+	p.failed()
 }
 
-func (p *Process) signal(s syscall.Signal) {
+// failed ends the current run, or a start attempt, as failed. Called with p.m
+// held.
+func (p *Process) failed() {
+	p.health = ""
+	p.ready = false
+	p.exit = statusFailed
+	_ = p.transition(statusFailed)
+}
+
+func (p *Process) signal(s syscall.Signal) error {
 	p.m.Lock()
 	defer p.m.Unlock()
 
 	process := p.process
 
 	if process == nil {
-		return
+		return nil
 	}
 
-	err := process.Signal(s)
+	err := p.deliver(process, s)
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
 	if err != nil {
 		p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, signal %s failed: %s", p.uuid, s, err))
-		return
+		return err
 	}
 
 	p.pushEvent(eventKindData, fmt.Sprintf("process: %s, signal %s success, pid: %d", p.uuid, s, process.Pid))
+
+	return nil
+}
+
+func (p *Process) outcome() *Outcome {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	o := &Outcome{
+		UUID: p.uuid,
+		Name: p.attributes.name,
+		Pid:  -1,
+	}
+
+	if p.process != nil {
+		o.Pid = p.process.Pid
+	}
+
+	return o
 }
 
 type Metadata struct {
 	UUID     string
+	Name     string
+	Labels   map[string]string
+	Status   string
+	Since    time.Time
 	Pid      int
 	Alive    bool
+	Adopted  bool
 	Dir      string
 	Cmd      string
 	Argv     []string
@@ -153,9 +552,46 @@ type Metadata struct {
 	Files    []string
 	Restart  bool
 	Cron     string
+	Capture  bool
+	Rotation *Rotation
 	Events   []string
+
 	ExitCode int
 	ExitData string
+
+	RestartPolicy *RestartPolicy
+	Restarts      int
+	NextRetry     time.Time
+
+	HealthCheck *HealthCheck
+	Health      string
+	Readiness   *Readiness
+	Ready       bool
+	DependsOn   []*Dependency
+	KillMode    string
+	Session     bool
+	User        string
+	Group       string
+	Groups      []string
+	Limits      []*Rlimit
+
+	Cgroup        *Cgroup
+	CgroupPath    string
+	MemoryCurrent Size
+	CPUUsage      time.Duration
+	CPUThrottled  time.Duration
+	OOMKills      int64
+
+	Stats  *Stats
+	Uptime time.Duration
+
+	CronRuns     int
+	CronFailures int
+
+	MaxMemoryRestart Size
+	MaxUptime        time.Duration
+	Recycles         map[string]int
+	HealthRestarts   int
 }
 
 func (p *Process) metadata() *Metadata {
@@ -163,22 +599,81 @@ func (p *Process) metadata() *Metadata {
 	defer p.m.Unlock()
 
 	m := &Metadata{
-		UUID:    p.uuid,
-		Pid:     -1,
-		Alive:   false,
-		Dir:     p.attributes.dir,
-		Cmd:     p.attributes.cmd,
-		Argv:    p.attributes.argv,
-		Env:     p.attributes.env,
-		Files:   p.attributes.files,
-		Restart: p.attributes.restart,
-		Cron:    p.attributes.cron,
-		Events:  nil,
+		UUID:     p.uuid,
+		Name:     p.attributes.name,
+		Labels:   p.attributes.labels,
+		Status:   p.status,
+		Since:    p.since,
+		Pid:      -1,
+		Alive:    false,
+		Dir:      p.attributes.dir,
+		Cmd:      p.attributes.cmd,
+		Argv:     p.attributes.argv,
+		Env:      p.attributes.env,
+		Files:    p.attributes.files,
+		Restart:  p.attributes.restart,
+		Cron:     p.attributes.cron,
+		Capture:  p.attributes.capture,
+		Rotation: p.attributes.rotation,
+		Events:   nil,
+
+		RestartPolicy: p.attributes.policy(),
+		Restarts:      p.restarts,
+		NextRetry:     p.nextRetry,
+
+		HealthCheck: p.attributes.healthCheck,
+		Health:      p.health,
+		Readiness:   p.attributes.readiness,
+		Ready:       p.ready,
+		DependsOn:   p.attributes.dependsOn,
+		KillMode:    p.attributes.killMode(),
+		Session:     p.attributes.session,
+		User:        p.attributes.user,
+		Group:       p.attributes.group,
+		Groups:      p.attributes.groups,
+		Limits:      p.attributes.limits,
+
+		Cgroup: p.attributes.cgroup,
+
+		CronRuns:     p.cronRuns,
+		CronFailures: p.cronFailures,
+
+		MaxMemoryRestart: p.attributes.maxMemoryRestart,
+		MaxUptime:        p.attributes.maxUptime,
+		HealthRestarts:   p.healthRestarts,
+	}
+
+	if len(p.recycles) > 0 {
+		m.Recycles = make(map[string]int, len(p.recycles))
+		for reason, n := range p.recycles {
+			m.Recycles[reason] = n
+		}
 	}
 
 	if p.process != nil {
 		m.Pid = p.process.Pid
-		m.Alive = p.process.Signal(syscall.Signal(0)) == nil
+		m.Alive = p.status == statusRunning || p.status == statusStopping
+		m.Adopted = p.adopted
+	}
+
+	if m.Alive {
+		m.Stats = p.stats
+		m.Uptime = p.uptime()
+	}
+
+	if cgroup := p.cgroup(); cgroup != "" {
+		stats := readCgroupStats(cgroup)
+
+		m.CgroupPath = cgroup
+		m.MemoryCurrent = stats.memoryCurrent
+		m.CPUUsage = stats.cpuUsage
+		m.CPUThrottled = stats.cpuThrottled
+		m.OOMKills = stats.oomKills
+	}
+
+	if p.gone {
+		m.ExitCode = -1
+		m.ExitData = "exited, status unknown"
 	}
 
 	if p.processState != nil {
@@ -0,0 +1,328 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ReadyTCP    = "tcp"
+	ReadyHTTP   = "http"
+	ReadyLog    = "log"
+	ReadyNotify = "notify"
+
+	WaitReady   = "ready"
+	WaitRunning = "running"
+	WaitExited  = "exited"
+
+	notifySize = 4096
+)
+
+// Readiness tells when a running process is serving: once Address accepts a
+// tcp connection, URL answers 200, a log line matches Pattern, or the process
+// sends READY=1 to the socket in NOTIFY_SOCKET, as with sd_notify.
+type Readiness struct {
+	Type    string `json:"type" yaml:"type" toml:"type"`
+	Address string `json:"address" yaml:"address" toml:"address"`
+	URL     string `json:"url" yaml:"url" toml:"url"`
+	Pattern string `json:"pattern" yaml:"pattern" toml:"pattern"`
+
+	// Interval and Timeout apply to the tcp and http probes.
+	Interval time.Duration `json:"interval" yaml:"interval" toml:"interval"`
+	Timeout  time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+func (r *Readiness) String() string {
+	if r == nil {
+		return ""
+	}
+
+	switch r.Type {
+	case ReadyTCP:
+		return fmt.Sprintf("%s %s interval=%s timeout=%s", r.Type, r.Address, r.Interval, r.Timeout)
+	case ReadyHTTP:
+		return fmt.Sprintf("%s %s interval=%s timeout=%s", r.Type, r.URL, r.Interval, r.Timeout)
+	case ReadyLog:
+		return fmt.Sprintf("%s %q", r.Type, r.Pattern)
+	}
+
+	return r.Type
+}
+
+func (r *Readiness) validate(capture bool) error {
+	switch r.Type {
+	case ReadyTCP:
+		if r.Address == "" {
+			return errors.New("tcp readiness requires address")
+		}
+	case ReadyHTTP:
+		if r.URL == "" {
+			return errors.New("http readiness requires url")
+		}
+	case ReadyLog:
+		if !capture {
+			return errors.New("log readiness requires captured output")
+		}
+		_, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid readiness pattern: %w", err)
+		}
+	case ReadyNotify:
+	default:
+		return fmt.Errorf("invalid readiness type: %s", r.Type)
+	}
+
+	if r.Interval < 0 || r.Timeout < 0 {
+		return fmt.Errorf("invalid readiness: %s", r)
+	}
+
+	return nil
+}
+
+func (r *Readiness) healthCheck() *HealthCheck {
+	check := &HealthCheck{
+		Type:     HealthTCP,
+		Address:  r.Address,
+		URL:      r.URL,
+		Interval: r.Interval,
+		Timeout:  r.Timeout,
+	}
+	if r.Type == ReadyHTTP {
+		check.Type = HealthHTTP
+	}
+	if check.Interval == 0 {
+		check.Interval = time.Millisecond * 500
+	}
+
+	return check.withDefaults()
+}
+
+// listenNotify opens the notify socket of a run, its path is handed to the
+// process in NOTIFY_SOCKET. The socket belongs to the user the process runs as,
+// so it can send to it.
+func (p *Process) listenNotify(id *identity) (*net.UnixConn, error) {
+	runtime, err := runtimeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// a dir of its own that only the user of the process may enter, no shared
+	// dir such as /tmp.
+	dir := filepath.Join(runtime, "process-"+p.uuid)
+
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Mkdir(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "notify")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err == nil && id != nil {
+		err = os.Chown(path, int(id.credential.Uid), int(id.credential.Gid))
+		if err == nil {
+			err = os.Chown(dir, int(id.credential.Uid), int(id.credential.Gid))
+		}
+		if err != nil {
+			_ = conn.Close()
+		}
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// closeNotify closes the notify socket and removes its dir.
+func closeNotify(conn *net.UnixConn) {
+	_ = conn.Close()
+	_ = os.RemoveAll(filepath.Dir(conn.LocalAddr().String()))
+}
+
+func notifyEnv(env []string, path string) []string {
+	return setEnv(env, "NOTIFY_SOCKET", path)
+}
+
+// watchReadiness waits for the current run to become ready, a log pattern is
+// matched against the lines after cursor. Called with p.m held.
+func (p *Process) watchReadiness(notify *net.UnixConn, cursor uint64) {
+	p.ready = false
+
+	readiness := p.attributes.readiness
+	switch {
+	case readiness == nil:
+		p.markReady(p.runs)
+	case readiness.Type == ReadyTCP || readiness.Type == ReadyHTTP:
+		go p.pollReady(readiness.healthCheck(), p.runs, p.done)
+	case p.adopted:
+		// the output and the notify socket of an adopted process are gone,
+		// it was ready before the manager restarted.
+		p.markReady(p.runs)
+	case readiness.Type == ReadyLog:
+		go p.matchReady(regexp.MustCompile(readiness.Pattern), cursor, p.runs, p.done)
+	case readiness.Type == ReadyNotify:
+		go p.readNotify(notify, p.runs, p.done)
+	}
+}
+
+// markReady marks the given run as ready, unless another run started since.
+// Called with p.m held.
+func (p *Process) markReady(run uint64) {
+	if p.runs != run || p.ready || p.status != statusRunning {
+		return
+	}
+
+	p.ready = true
+	p.changed()
+
+	if p.attributes.readiness != nil {
+		p.pushEvent(eventKindData, fmt.Sprintf("process: %s, ready", p.uuid))
+	}
+}
+
+func (p *Process) pollReady(check *HealthCheck, run uint64, done chan struct{}) {
+	t := time.NewTicker(check.Interval)
+	defer t.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), check.Timeout)
+		err := p.manager.check(ctx, check, nil, "")
+		cancel()
+
+		if err == nil {
+			p.m.Lock()
+			p.markReady(run)
+			p.m.Unlock()
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *Process) matchReady(pattern *regexp.Regexp, cursor uint64, run uint64, done chan struct{}) {
+	all := map[string]bool{streamStdout: true, streamStderr: true}
+
+	for {
+		lines, next, changed := p.logs.read(cursor, -1, all)
+		for _, line := range lines {
+			if pattern.MatchString(line.Text) {
+				p.m.Lock()
+				p.markReady(run)
+				p.m.Unlock()
+				return
+			}
+		}
+		cursor = next
+
+		select {
+		case <-done:
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (p *Process) readNotify(conn *net.UnixConn, run uint64, done chan struct{}) {
+	go func() {
+		<-done
+		closeNotify(conn)
+	}()
+
+	buf := make([]byte, notifySize)
+	for {
+		n, _, err := conn.ReadFromUnix(buf)
+		if err != nil {
+			return
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line == "READY=1" {
+				p.m.Lock()
+				p.markReady(run)
+				p.m.Unlock()
+			}
+		}
+	}
+}
+
+// changed wakes up everyone waiting for the process to change. Called with
+// p.m held.
+func (p *Process) changed() {
+	close(p.change)
+	p.change = make(chan struct{})
+
+	p.manager.changed()
+}
+
+// reached reports whether the process meets a wait condition, or an error
+// when it never will. Called with p.m held.
+func (p *Process) reached(condition string) (bool, error) {
+	switch condition {
+	case WaitReady:
+		if p.status == statusFatal {
+			return false, fmt.Errorf("process: %s, fatal, it will not become ready", p.uuid)
+		}
+		return p.status == statusRunning && p.ready, nil
+	case WaitRunning:
+		if p.status == statusFatal {
+			return false, fmt.Errorf("process: %s, fatal, it will not run", p.uuid)
+		}
+		return p.status == statusRunning, nil
+	case WaitExited:
+		switch p.status {
+		case statusCreated, statusWaiting, statusStarting, statusRunning, statusStopping:
+			return false, nil
+		}
+		return true, nil
+	}
+
+	return false, fmt.Errorf("unknown wait condition: %s", condition)
+}
+
+// Wait blocks until the process meets the condition, ready, running or
+// exited, or ctx is done.
+func (m *Manager) Wait(ctx context.Context, id, condition string) (*Metadata, error) {
+	process, err := m.searchProcess(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		process.m.Lock()
+		ok, err := process.reached(condition)
+		change := process.change
+		process.m.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return process.metadata(), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("process: %s, wait for %s: %w", process.uuid, condition, ctx.Err())
+		case <-change:
+		}
+	}
+}
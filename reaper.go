@@ -0,0 +1,54 @@
+package process
+
+import (
+	"os"
+	"sync"
+)
+
+// reaper collects zombies re-parented to the manager while it acts as a child
+// subreaper, children the manager waits on itself are left alone.
+type reaper struct {
+	spawn    sync.RWMutex
+	lock     sync.Mutex
+	children map[int]struct{}
+}
+
+func newReaper() *reaper {
+	return &reaper{
+		children: make(map[int]struct{}),
+	}
+}
+
+// start runs fn, which forks a child waited on by the caller, and keeps the
+// reaper from collecting that child.
+func (r *reaper) start(fn func() (*os.Process, error)) (*os.Process, error) {
+	r.spawn.RLock()
+	defer r.spawn.RUnlock()
+
+	process, err := fn()
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.children[process.Pid] = struct{}{}
+
+	return process, nil
+}
+
+func (r *reaper) release(pid int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.children, pid)
+}
+
+func (r *reaper) owned(pid int) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, ok := r.children[pid]
+	return ok
+}
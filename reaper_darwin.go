@@ -0,0 +1,12 @@
+//go:build darwin
+// +build darwin
+
+package process
+
+func setSubreaper() error {
+	return errProcUnsupported
+}
+
+func (r *reaper) reap() []int {
+	return nil
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package process
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func setSubreaper() error {
+	return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
+
+func (r *reaper) reap() []int {
+	r.spawn.Lock()
+	defer r.spawn.Unlock()
+
+	pids, err := listPids()
+	if err != nil {
+		return nil
+	}
+
+	self := os.Getpid()
+
+	var reaped []int
+	for _, pid := range pids {
+		s, err := readProcStat(pid)
+		if err != nil || s.ppid != self || s.state != 'Z' || r.owned(pid) {
+			continue
+		}
+
+		var status syscall.WaitStatus
+		n, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+		if err == nil && n == pid {
+			reaped = append(reaped, pid)
+		}
+	}
+	return reaped
+}
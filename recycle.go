@@ -0,0 +1,79 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	RecycleMemory = "max_memory"
+	RecycleUptime = "max_uptime"
+
+	// recycleInterval is the least time between two recycles of a process,
+	// a process over its limit right after the start does not flap.
+	recycleInterval = time.Minute
+
+	recycleGracefully = time.Second * 5
+)
+
+// recycle restarts a running process gracefully once its resident memory
+// passed max_memory_restart or its uptime passed max_uptime, each recycle is
+// recorded with its reason.
+func (p *Process) recycle() {
+	p.m.Lock()
+
+	if p.status != statusRunning {
+		p.m.Unlock()
+		return
+	}
+
+	var reason, detail string
+
+	uptime := p.uptime()
+	switch {
+	case p.attributes.maxMemoryRestart > 0 && p.stats != nil && p.stats.RSS > p.attributes.maxMemoryRestart:
+		reason = RecycleMemory
+		detail = fmt.Sprintf("rss %s above %s", formatBytes(p.stats.RSS), p.attributes.maxMemoryRestart)
+	case p.attributes.maxUptime > 0 && uptime > p.attributes.maxUptime:
+		reason = RecycleUptime
+		detail = fmt.Sprintf("uptime %s above %s", uptime.Round(time.Second), p.attributes.maxUptime)
+	}
+
+	if reason == "" || !p.recycledAt.IsZero() && time.Since(p.recycledAt) < recycleInterval {
+		p.m.Unlock()
+		return
+	}
+
+	p.recycledAt = time.Now()
+	if p.recycles == nil {
+		p.recycles = make(map[string]int)
+	}
+	p.recycles[reason]++
+
+	p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, recycle, reason: %s, %s", p.uuid, reason, detail))
+	p.m.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), operateTimeout+recycleGracefully)
+	defer cancel()
+
+	_, err := p.manager.Operate(ctx, &OperateRestart{
+		ID:         p.uuid,
+		Gracefully: recycleGracefully,
+	})
+	if err != nil {
+		p.m.Lock()
+		p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, recycle, reason: %s, restart failed: %s", p.uuid, reason, err))
+		p.m.Unlock()
+	}
+}
+
+func formatRecycles(recycles map[string]int) []string {
+	s := make([]string, 0, len(recycles))
+	for reason, n := range recycles {
+		s = append(s, fmt.Sprintf("%s=%d", reason, n))
+	}
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,809 @@
+package process
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// restMaxBody bounds the json body of a request, an apply carries a whole
+// ecosystem.
+const restMaxBody = 8 << 20
+
+var errInvalidRequest = errors.New("invalid request")
+
+func invalidRequest(format string, a ...interface{}) error {
+	return &kindError{kind: errInvalidRequest, err: fmt.Errorf(format, a...)}
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+// restParam is a query parameter of a route, kind is string, integer,
+// boolean, duration or date-time.
+type restParam struct {
+	name        string
+	kind        string
+	description string
+}
+
+// restRoute is an endpoint of the REST API. The OpenAPI document is generated
+// from the routes: argv is the json body, reply what the handler returns with
+// status and stream, if set, the lines of an application/x-ndjson response.
+type restRoute struct {
+	method      string
+	path        string
+	operationID string
+	summary     string
+	query       []restParam
+	argv        interface{}
+	reply       interface{}
+	stream      interface{}
+	status      int
+	public      bool
+
+	handle func(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error)
+}
+
+var (
+	selectorParam = restParam{"selector", "string", "label selector, e.g. env=prod,tier!=batch"}
+	gracefulParam = restParam{"gracefully", "duration", "time to exit after SIGTERM before SIGKILL, e.g. 5s"}
+)
+
+var restRoutes = []*restRoute{
+	{
+		method:      http.MethodGet,
+		path:        "/v1/processes",
+		operationID: "listProcesses",
+		summary:     "List the processes",
+		query: []restParam{
+			selectorParam,
+			{"cmd", "string", "command, as a path or a base name"},
+			{"status", "string", "status"},
+		},
+		reply:  &ListReply{},
+		status: http.StatusOK,
+		handle: restList,
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/v1/processes",
+		operationID: "startProcess",
+		summary:     "Start a process",
+		argv:        &StartArgv{},
+		reply:       &StartReply{},
+		status:      http.StatusCreated,
+		handle:      restStart,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/v1/processes/{id}",
+		operationID: "getProcess",
+		summary:     "Get a process by uuid or name",
+		reply:       map[string]string{},
+		status:      http.StatusOK,
+		handle:      restGet,
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/v1/processes/{id}/stop",
+		operationID: "stopProcess",
+		summary:     "Stop a process",
+		query: []restParam{
+			gracefulParam,
+			{"prune", "boolean", "remove the process once stopped"},
+		},
+		reply:  &StopReply{},
+		status: http.StatusOK,
+		handle: restStop,
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/v1/processes/{id}/kill",
+		operationID: "killProcess",
+		summary:     "Kill a process",
+		query: []restParam{
+			{"prune", "boolean", "remove the process once killed"},
+		},
+		reply:  &KillReply{},
+		status: http.StatusOK,
+		handle: restKill,
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/v1/processes/{id}/restart",
+		operationID: "restartProcess",
+		summary:     "Restart a process",
+		query: []restParam{
+			gracefulParam,
+			{"cascade", "boolean", "restart the processes depending on it too"},
+		},
+		reply:  &RestartReply{},
+		status: http.StatusOK,
+		handle: restRestart,
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/v1/processes/{id}/signal",
+		operationID: "signalProcess",
+		summary:     "Send a signal to a process",
+		query: []restParam{
+			{"signal", "integer", "signal number"},
+		},
+		reply:  &SignalReply{},
+		status: http.StatusOK,
+		handle: restSignal,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/v1/processes/{id}/logs",
+		operationID: "processLogs",
+		summary:     "Read the captured output of a process",
+		query: []restParam{
+			{"lines", "integer", "last lines to return"},
+			{"stdout", "boolean", "stdout only"},
+			{"stderr", "boolean", "stderr only"},
+			{"cursor", "integer", "return the lines after this cursor"},
+			{"follow", "boolean", "stream the lines as they are written, one json line each"},
+		},
+		reply:  &LogsReply{},
+		stream: &LogLine{},
+		status: http.StatusOK,
+		handle: restLogs,
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/v1/processes/{id}/rotate",
+		operationID: "rotateProcessLogs",
+		summary:     "Rotate the output files of a process",
+		reply:       &RotateReply{},
+		status:      http.StatusOK,
+		handle:      restRotate,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/v1/processes/{id}/wait",
+		operationID: "waitProcess",
+		summary:     "Wait for a process to reach a condition",
+		query: []restParam{
+			{"for", "string", "condition: ready, running or exited"},
+			{"timeout", "duration", "time to wait, e.g. 30s"},
+		},
+		reply:  &WaitReply{},
+		status: http.StatusOK,
+		handle: restWait,
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/v1/apply",
+		operationID: "apply",
+		summary:     "Apply the processes of an ecosystem source",
+		argv:        &ApplyArgv{},
+		reply:       &ApplyReply{},
+		status:      http.StatusOK,
+		handle:      restApply,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/v1/audit",
+		operationID: "queryAudit",
+		summary:     "Query the audit log",
+		query: []restParam{
+			{"since", "date-time", "entries at or after this time"},
+			{"until", "date-time", "entries at or before this time"},
+			{"process", "string", "entries of this process, by uuid or name"},
+			{"operation", "string", "entries of this operation"},
+			{"limit", "integer", "last entries to return"},
+		},
+		reply:  &AuditReply{},
+		status: http.StatusOK,
+		handle: restAudit,
+	},
+}
+
+// the document describes itself, its route can only join the others once
+// they are initialized.
+func init() {
+	restRoutes = append(restRoutes, &restRoute{
+		method:      http.MethodGet,
+		path:        "/v1/openapi.json",
+		operationID: "openAPI",
+		summary:     "The OpenAPI document of this API",
+		reply:       map[string]interface{}{},
+		status:      http.StatusOK,
+		public:      true,
+		handle: func(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+			return OpenAPI(), nil
+		},
+	})
+}
+
+func (route *restRoute) match(path string) (string, bool) {
+	want := strings.Split(strings.Trim(route.path, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return "", false
+	}
+
+	id := ""
+	for i := range want {
+		switch {
+		case want[i] == "{id}" && got[i] != "":
+			id = got[i]
+		case want[i] != got[i]:
+			return "", false
+		}
+	}
+
+	return id, true
+}
+
+type restHandler struct {
+	service *RPC
+	auth    *Auth
+}
+
+// RESTHandler serves the REST API of the service under /v1. Callers are
+// authenticated like those of the rpc service, by a verified client
+// certificate of the TLS server and a bearer token when auth has tokens, and
+// share its policy and audit log. Nil auth accepts any.
+func RESTHandler(service *RPC, auth *Auth) http.Handler {
+	return &restHandler{service: service, auth: auth}
+}
+
+// ServeREST serves the REST API on listener until ctx is done, over TLS when
+// auth has a TLS config.
+func (r *RPC) ServeREST(ctx context.Context, listener net.Listener, auth *Auth) error {
+	err := checkExposure("rest", listener, auth)
+	if err != nil {
+		return err
+	}
+
+	if auth != nil && auth.TLS != nil {
+		listener = tls.NewListener(listener, auth.TLS)
+	}
+
+	handler := RESTHandler(r, auth)
+	if auth == nil || auth.TLS == nil {
+		handler = restHost(handler)
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: readHeaderTimeout}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// restHost refuses a host other than localhost or an ip address. Without TLS a
+// page of another site can rebind its own name to the listen address, its
+// requests then name that site.
+func restHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		if host != "localhost" && net.ParseIP(strings.Trim(host, "[]")) == nil {
+			writeRESTError(w, fmt.Errorf("%w: host %s", errPermissionDenied, r.Host))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *restHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// a browser sends the origin of the page along, a page of another site
+	// must not change anything, even where it gets the client certificate.
+	if origin := r.Header.Get("Origin"); origin != "" && r.Method != http.MethodGet {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			writeRESTError(w, fmt.Errorf("%w: cross-origin request from %s", errPermissionDenied, origin))
+			return
+		}
+	}
+
+	var allowed []string
+
+	for _, route := range restRoutes {
+		id, ok := route.match(r.URL.Path)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+
+		peer, err := h.authenticate(r)
+		if err != nil && !route.public {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeRESTError(w, err)
+			return
+		}
+		if err != nil {
+			// a nil peer is the manager itself, which may do anything.
+			peer = &Peer{Address: r.RemoteAddr}
+		}
+
+		reply, err := route.handle(h.service.session(peer), w, r, id)
+		if err != nil {
+			writeRESTError(w, err)
+			return
+		}
+		if reply != nil {
+			writeJSON(w, route.status, reply)
+		}
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSON(w, http.StatusMethodNotAllowed, &restError{Error: "method not allowed"})
+		return
+	}
+
+	writeJSON(w, http.StatusNotFound, &restError{Error: "not found: " + r.URL.Path})
+}
+
+// authenticate returns the caller of a request. The certificate was verified
+// by the TLS handshake, the token is checked here.
+func (h *restHandler) authenticate(r *http.Request) (*Peer, error) {
+	peer := &Peer{Address: r.RemoteAddr}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		peer.CommonName = r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	if h.auth == nil || !h.auth.tokens() {
+		return peer, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, fmt.Errorf("%w: bearer token required", errUnauthenticated)
+	}
+
+	peer.Token = h.auth.token(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	if peer.Token == "" {
+		return nil, fmt.Errorf("%w: invalid token", errUnauthenticated)
+	}
+
+	return peer, nil
+}
+
+// restStatus maps an error to the status of its response. An operation the
+// manager refused for any other reason is unprocessable.
+func restStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, errPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+func writeRESTError(w http.ResponseWriter, err error) {
+	writeJSON(w, restStatus(err), &restError{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logrus.WithError(err).Debug("rest reply failed")
+	}
+}
+
+// call runs a method of the service and records it in the audit log, as the
+// rpc server does for its calls.
+func (r *RPC) call(operation string, argv, reply interface{}, method func() error) error {
+	started := time.Now()
+
+	err := method()
+
+	if r.audit == nil {
+		return err
+	}
+
+	entry := &AuditEntry{
+		Time:      started,
+		Peer:      r.peer.String(),
+		Address:   r.peer.Address,
+		Operation: operation,
+		Outcome:   auditOK,
+	}
+	entry.Args, entry.Target = auditArgs(argv)
+
+	var failed []string
+	entry.Processes, failed = auditProcesses(reply)
+	if err != nil {
+		entry.Error = err.Error()
+	} else if len(failed) > 0 {
+		entry.Error = strings.Join(failed, "; ")
+	}
+	if entry.Error != "" {
+		entry.Outcome = auditError
+	}
+
+	r.audit.record(entry)
+
+	return err
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, argv interface{}) error {
+	// a form of another site can post anything but json without asking first.
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return invalidRequest("content type %q, want application/json", r.Header.Get("Content-Type"))
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, restMaxBody))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(argv)
+	if err != nil {
+		return invalidRequest("decode body: %v", err)
+	}
+	return nil
+}
+
+func queryBool(r *http.Request, name string) (bool, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, invalidRequest("invalid %s: %s", name, s)
+	}
+	return b, nil
+}
+
+func queryInt(r *http.Request, name string) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, invalidRequest("invalid %s: %s", name, s)
+	}
+	return n, nil
+}
+
+func queryDuration(r *http.Request, name string) (time.Duration, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, invalidRequest("invalid %s: %s", name, s)
+	}
+	return d, nil
+}
+
+func queryTime(r *http.Request, name string) (time.Time, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, invalidRequest("invalid %s: %s", name, s)
+	}
+	return t, nil
+}
+
+func restList(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	query := r.URL.Query()
+
+	argv := &ListArgv{
+		Cmd:      query.Get("cmd"),
+		Status:   query.Get("status"),
+		Selector: query.Get("selector"),
+	}
+	reply := &ListReply{}
+
+	return reply, s.call("list", argv, reply, func() error {
+		return s.List(argv, reply)
+	})
+}
+
+func restGet(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	argv := &ListArgv{}
+	reply := &ListReply{}
+
+	err := s.call("list", argv, reply, func() error {
+		return s.List(argv, reply)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the list leaves out the processes the caller may not see.
+	for _, row := range reply.Metadata {
+		if row["UUID"] == id || row["Name"] == id {
+			return row, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w process: %s", ErrNotFound, id)
+}
+
+func restStart(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	argv := &StartArgv{}
+
+	err := decodeBody(w, r, argv)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &StartReply{}
+
+	err = s.call("start", argv, reply, func() error {
+		return s.Start(argv, reply)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Location", "/v1/processes/"+reply.UUID)
+
+	return reply, nil
+}
+
+func restStop(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	gracefully, err := queryDuration(r, "gracefully")
+	if err != nil {
+		return nil, err
+	}
+
+	prune, err := queryBool(r, "prune")
+	if err != nil {
+		return nil, err
+	}
+
+	argv := &StopArgv{ID: id, Gracefully: gracefully, Prune: prune}
+	reply := &StopReply{}
+
+	return reply, s.call("stop", argv, reply, func() error {
+		return s.Stop(argv, reply)
+	})
+}
+
+func restKill(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	prune, err := queryBool(r, "prune")
+	if err != nil {
+		return nil, err
+	}
+
+	argv := &KillArgv{ID: id, Prune: prune}
+	reply := &KillReply{}
+
+	return reply, s.call("kill", argv, reply, func() error {
+		return s.Kill(argv, reply)
+	})
+}
+
+func restRestart(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	gracefully, err := queryDuration(r, "gracefully")
+	if err != nil {
+		return nil, err
+	}
+
+	cascade, err := queryBool(r, "cascade")
+	if err != nil {
+		return nil, err
+	}
+
+	argv := &RestartArgv{ID: id, Gracefully: gracefully, Cascade: cascade}
+	reply := &RestartReply{}
+
+	return reply, s.call("restart", argv, reply, func() error {
+		return s.Restart(argv, reply)
+	})
+}
+
+func restSignal(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	signal, err := queryInt(r, "signal")
+	if err != nil {
+		return nil, err
+	}
+	if signal == 0 {
+		return nil, invalidRequest("signal required")
+	}
+
+	argv := &SignalArgv{ID: id, Signal: syscall.Signal(signal)}
+	reply := &SignalReply{}
+
+	return reply, s.call("signal", argv, reply, func() error {
+		return s.Signal(argv, reply)
+	})
+}
+
+func restLogs(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	argv := &LogsArgv{ID: id}
+
+	var err error
+
+	argv.Lines, err = queryInt(r, "lines")
+	if err != nil {
+		return nil, err
+	}
+
+	argv.Stdout, err = queryBool(r, "stdout")
+	if err != nil {
+		return nil, err
+	}
+
+	argv.Stderr, err = queryBool(r, "stderr")
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := queryInt(r, "cursor")
+	if err != nil {
+		return nil, err
+	}
+	argv.Cursor = uint64(cursor)
+
+	follow, err := queryBool(r, "follow")
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &LogsReply{}
+
+	err = s.call("logs", argv, reply, func() error {
+		return s.Logs(argv, reply)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !follow {
+		return reply, nil
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+
+	// the stream has started, an error ends it with an error line.
+	for {
+		for _, line := range reply.Lines {
+			err = encoder.Encode(line)
+			if err != nil {
+				return nil, nil
+			}
+		}
+		flusher.Flush()
+
+		if r.Context().Err() != nil {
+			return nil, nil
+		}
+
+		argv.Cursor = reply.Cursor
+		argv.Lines = 0
+		argv.Wait = logsWait
+
+		reply = &LogsReply{}
+
+		err = s.Logs(argv, reply)
+		if err != nil {
+			_ = encoder.Encode(&restError{Error: err.Error()})
+			return nil, nil
+		}
+	}
+}
+
+func restRotate(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	argv := &RotateArgv{ID: id}
+	reply := &RotateReply{}
+
+	return reply, s.call("rotate", argv, reply, func() error {
+		return s.Rotate(argv, reply)
+	})
+}
+
+func restWait(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	timeout, err := queryDuration(r, "timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	argv := &WaitArgv{ID: id, For: r.URL.Query().Get("for"), Timeout: timeout}
+	reply := &WaitReply{}
+
+	return reply, s.call("wait", argv, reply, func() error {
+		return s.Wait(argv, reply)
+	})
+}
+
+func restApply(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	argv := &ApplyArgv{}
+
+	err := decodeBody(w, r, argv)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &ApplyReply{}
+
+	return reply, s.call("apply", argv, reply, func() error {
+		return s.Apply(argv, reply)
+	})
+}
+
+func restAudit(s *RPC, w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	argv := &AuditArgv{Process: r.URL.Query().Get("process"), Operation: r.URL.Query().Get("operation")}
+
+	var err error
+
+	argv.Since, err = queryTime(r, "since")
+	if err != nil {
+		return nil, err
+	}
+
+	argv.Until, err = queryTime(r, "until")
+	if err != nil {
+		return nil, err
+	}
+
+	argv.Limit, err = queryInt(r, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &AuditReply{}
+
+	return reply, s.call("audit", argv, reply, func() error {
+		return s.Audit(argv, reply)
+	})
+}
@@ -0,0 +1,92 @@
+package process
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRESTPublicRouteWithPolicy(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	policy := &Policy{
+		Tokens: map[string]string{"ci": hex.EncodeToString(sum[:])},
+		Roles:  map[string][]*Grant{"reader": {{Verbs: []string{"list"}}}},
+		Bindings: []*Binding{
+			{Role: "reader", Subjects: []string{"token:ci"}},
+		},
+	}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	service := NewRPC(NewManager(), WithPolicy(policy))
+	handler := RESTHandler(service, &Auth{Policy: policy})
+
+	tests := []struct {
+		path   string
+		header string
+		status int
+	}{
+		{"/v1/openapi.json", "", http.StatusOK},
+		{"/v1/openapi.json", "Bearer wrong", http.StatusOK},
+		{"/v1/processes", "", http.StatusUnauthorized},
+		{"/v1/processes", "Bearer wrong", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("GET %s with %q: status %d, want %d", test.path, test.header, w.Code, test.status)
+		}
+	}
+}
+
+func TestRESTRefusesCrossSiteRequests(t *testing.T) {
+	handler := restHost(RESTHandler(NewRPC(NewManager()), nil))
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		host        string
+		origin      string
+		contentType string
+		status      int
+	}{
+		{"form post", http.MethodPost, "/v1/processes", "127.0.0.1:8081", "", "application/x-www-form-urlencoded", http.StatusBadRequest},
+		{"text post", http.MethodPost, "/v1/processes", "127.0.0.1:8081", "", "text/plain", http.StatusBadRequest},
+		{"other origin", http.MethodPost, "/v1/processes/web/stop", "127.0.0.1:8081", "http://example.com", "", http.StatusForbidden},
+		{"rebound name", http.MethodGet, "/v1/processes", "example.com:8081", "", "", http.StatusForbidden},
+		{"localhost", http.MethodGet, "/v1/processes", "localhost:8081", "", "", http.StatusOK},
+		{"ipv6", http.MethodGet, "/v1/processes", "[::1]:8081", "", "", http.StatusOK},
+		{"same origin", http.MethodGet, "/v1/processes", "127.0.0.1:8081", "http://127.0.0.1:8081", "", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader("{}"))
+		r.Host = test.host
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.name, w.Code, test.status, w.Body)
+		}
+	}
+}
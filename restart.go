@@ -0,0 +1,236 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	RestartAlways        = "always"
+	RestartOnFailure     = "on-failure"
+	RestartNever         = "never"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+// RestartPolicy decides whether an exited process is started again. Restarts
+// back off exponentially from InitialBackoff up to MaxBackoff while runs end
+// before MinUptime, more than MaxRestarts restarts after failed runs within
+// Window make the process fatal.
+type RestartPolicy struct {
+	Policy         string        `json:"policy" yaml:"policy" toml:"policy"`
+	InitialBackoff time.Duration `json:"initial_backoff" yaml:"initial_backoff" toml:"initial_backoff"`
+	MaxBackoff     time.Duration `json:"max_backoff" yaml:"max_backoff" toml:"max_backoff"`
+	Jitter         float64       `json:"jitter" yaml:"jitter" toml:"jitter"`
+	MaxRestarts    int           `json:"max_restarts" yaml:"max_restarts" toml:"max_restarts"`
+	Window         time.Duration `json:"window" yaml:"window" toml:"window"`
+	MinUptime      time.Duration `json:"min_uptime" yaml:"min_uptime" toml:"min_uptime"`
+}
+
+func (r *RestartPolicy) String() string {
+	if r == nil {
+		return ""
+	}
+	return fmt.Sprintf("policy=%s initial_backoff=%s max_backoff=%s jitter=%g max_restarts=%d window=%s min_uptime=%s",
+		r.Policy, r.InitialBackoff, r.MaxBackoff, r.Jitter, r.MaxRestarts, r.Window, r.MinUptime)
+}
+
+func (r *RestartPolicy) validate() error {
+	switch r.Policy {
+	case "", RestartAlways, RestartOnFailure, RestartNever, RestartUnlessStopped:
+	default:
+		return fmt.Errorf("invalid restart policy: %s", r.Policy)
+	}
+
+	if r.InitialBackoff < 0 || r.MaxBackoff < 0 || r.Jitter < 0 || r.Jitter > 1 || r.MaxRestarts < 0 || r.Window < 0 || r.MinUptime < 0 {
+		return fmt.Errorf("invalid restart policy: %s", r)
+	}
+
+	return nil
+}
+
+// policy returns the effective restart policy, the legacy restart flag maps to
+// always or never, unset durations fall back to defaults.
+func (a *Attributes) policy() *RestartPolicy {
+	policy := RestartPolicy{Policy: RestartNever}
+	if a.restart {
+		policy.Policy = RestartAlways
+	}
+
+	if a.restartPolicy != nil {
+		policy = *a.restartPolicy
+		if policy.Policy == "" {
+			policy.Policy = RestartAlways
+		}
+	}
+
+	if policy.InitialBackoff == 0 {
+		policy.InitialBackoff = time.Second
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = time.Minute
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+	if policy.MaxRestarts > 0 && policy.Window == 0 {
+		policy.Window = time.Minute
+	}
+
+	return &policy
+}
+
+// backoff records the end of a run and returns the delay before the next one,
+// ok is false when the policy does not restart the process. Called with p.m
+// held.
+func (p *Process) backoff(failed bool) (time.Duration, bool) {
+	policy := p.attributes.policy()
+
+	switch {
+	case p.stopped:
+		return 0, false
+	case policy.Policy == RestartNever:
+		return 0, false
+	case policy.Policy == RestartOnFailure && !failed:
+		return 0, false
+	}
+
+	now := time.Now()
+
+	// only failed runs count towards the restart limit, a run that stayed up
+	// ends a crash loop.
+	healthy := !failed && now.Sub(p.startedAt) >= policy.MinUptime
+	if healthy {
+		p.failures = 0
+		p.restartTimes = nil
+	} else {
+		p.failures++
+	}
+
+	if policy.MaxRestarts > 0 && !healthy {
+		recent := p.restartTimes[:0]
+		for _, t := range p.restartTimes {
+			if now.Sub(t) < policy.Window {
+				recent = append(recent, t)
+			}
+		}
+		p.restartTimes = recent
+
+		if len(p.restartTimes) >= policy.MaxRestarts {
+			_ = p.transition(statusFatal)
+			p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, crash looping, %d restarts within %s, giving up", p.uuid, len(p.restartTimes), policy.Window))
+			return 0, false
+		}
+	}
+
+	delay := policy.InitialBackoff
+	if p.failures > 1 {
+		delay = time.Duration(float64(policy.InitialBackoff) * math.Pow(2, float64(p.failures-1)))
+	}
+	if delay > policy.MaxBackoff || delay <= 0 {
+		delay = policy.MaxBackoff
+	}
+	if policy.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * policy.Jitter * float64(delay))
+	}
+
+	if !healthy {
+		p.restartTimes = append(p.restartTimes, now)
+	}
+
+	_ = p.transition(statusBackoff)
+
+	return delay, true
+}
+
+// scheduleRestart arms a retry of the given run according to the restart
+// policy. Called with p.m held.
+func (p *Process) scheduleRestart(failed bool) {
+	delay, ok := p.backoff(failed)
+	if !ok {
+		return
+	}
+
+	run := p.runs
+
+	p.nextRetry = time.Now().Add(delay)
+	p.retry = time.AfterFunc(delay, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		_, err := p.manager.Operate(ctx, &operateRetry{id: p.uuid, run: run})
+		if err != nil {
+			p.m.Lock()
+			p.pushEvent(eventKindErr, fmt.Sprintf("process: %s, restart failed: %s", p.uuid, err))
+			p.m.Unlock()
+		}
+	})
+
+	p.pushEvent(eventKindData, fmt.Sprintf("process: %s, restart in %s, failures: %d", p.uuid, delay.Round(time.Millisecond), p.failures))
+}
+
+// cancelRestart drops a pending retry, the process falls back to the state its
+// last run ended in. Called with p.m held.
+func (p *Process) cancelRestart() {
+	if p.retry != nil {
+		p.retry.Stop()
+		p.retry = nil
+	}
+	p.nextRetry = time.Time{}
+
+	if p.status == statusBackoff {
+		_ = p.transition(p.exit)
+	}
+}
+
+// markStopped records an explicit stop, the process is not restarted after
+// this run ends.
+func (p *Process) markStopped() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.stopped = true
+	p.cancelRestart()
+	p.cancelPending()
+}
+
+func (p *Process) resetBackoff() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.failures = 0
+	p.restartTimes = nil
+	p.cancelRestart()
+}
+
+// retry starts a run scheduled by the restart policy, unless the process was
+// started or stopped in the meantime.
+func (m *Manager) retry(process *Process, run uint64) error {
+	process.m.Lock()
+	stale := process.runs != run || process.stopped
+	process.retry = nil
+	process.nextRetry = time.Time{}
+	process.m.Unlock()
+
+	if stale || process.isRunning() {
+		return nil
+	}
+
+	process.m.Lock()
+	process.restarts++
+	process.m.Unlock()
+
+	err := process.start()
+
+	m.persist()
+
+	if err != nil {
+		process.m.Lock()
+		process.scheduleRestart(true)
+		process.m.Unlock()
+	}
+
+	return err
+}
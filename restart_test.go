@@ -0,0 +1,129 @@
+package process
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	type run struct {
+		failed bool
+		uptime time.Duration
+		delay  time.Duration
+		ok     bool
+	}
+
+	tests := []struct {
+		name    string
+		policy  RestartPolicy
+		stopped bool
+		runs    []run
+		status  string
+	}{
+		{
+			name:   "never",
+			policy: RestartPolicy{Policy: RestartNever},
+			runs:   []run{{failed: true}},
+			status: statusFailed,
+		},
+		{
+			name:   "on failure",
+			policy: RestartPolicy{Policy: RestartOnFailure},
+			runs:   []run{{failed: false}, {failed: true, delay: time.Second, ok: true}},
+			status: statusBackoff,
+		},
+		{
+			name:    "stopped",
+			policy:  RestartPolicy{Policy: RestartAlways},
+			stopped: true,
+			runs:    []run{{failed: true}},
+			status:  statusFailed,
+		},
+		{
+			name:   "doubles up to the maximum",
+			policy: RestartPolicy{Policy: RestartAlways, InitialBackoff: time.Second, MaxBackoff: time.Second * 5},
+			runs: []run{
+				{failed: true, delay: time.Second, ok: true},
+				{failed: true, delay: time.Second * 2, ok: true},
+				{failed: true, delay: time.Second * 4, ok: true},
+				{failed: true, delay: time.Second * 5, ok: true},
+				{failed: true, delay: time.Second * 5, ok: true},
+			},
+			status: statusBackoff,
+		},
+		{
+			name:   "a healthy run resets",
+			policy: RestartPolicy{Policy: RestartAlways, MinUptime: time.Second * 10},
+			runs: []run{
+				{failed: true, delay: time.Second, ok: true},
+				{failed: true, delay: time.Second * 2, ok: true},
+				{failed: false, uptime: time.Second * 20, delay: time.Second, ok: true},
+				{failed: true, delay: time.Second, ok: true},
+			},
+			status: statusBackoff,
+		},
+		{
+			name:   "a short run fails",
+			policy: RestartPolicy{Policy: RestartAlways, MinUptime: time.Second * 10},
+			runs: []run{
+				{failed: false, uptime: time.Second, delay: time.Second, ok: true},
+				{failed: false, uptime: time.Second, delay: time.Second * 2, ok: true},
+			},
+			status: statusBackoff,
+		},
+		{
+			name:   "crash loop",
+			policy: RestartPolicy{Policy: RestartAlways, MaxRestarts: 2, Window: time.Minute},
+			runs: []run{
+				{failed: true, delay: time.Second, ok: true},
+				{failed: true, delay: time.Second * 2, ok: true},
+				{failed: true},
+			},
+			status: statusFatal,
+		},
+		{
+			name:   "healthy runs do not count towards the limit",
+			policy: RestartPolicy{Policy: RestartAlways, MaxRestarts: 1, Window: time.Minute},
+			runs: []run{
+				{failed: false, delay: time.Second, ok: true},
+				{failed: false, delay: time.Second, ok: true},
+				{failed: true, delay: time.Second, ok: true},
+			},
+			status: statusBackoff,
+		},
+	}
+
+	for _, test := range tests {
+		policy := test.policy
+		p := NewManager().newProcess("test", &Attributes{restartPolicy: &policy})
+		p.stopped = test.stopped
+
+		for i, r := range test.runs {
+			p.status = statusFailed
+			p.startedAt = time.Now().Add(-r.uptime)
+
+			delay, ok := p.backoff(r.failed)
+			if ok != r.ok || delay != r.delay {
+				t.Errorf("%s: run %d: backoff %s %v, want %s %v", test.name, i, delay, ok, r.delay, r.ok)
+			}
+		}
+
+		if p.status != test.status {
+			t.Errorf("%s: status %s, want %s", test.name, p.status, test.status)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := NewManager().newProcess("test", &Attributes{restartPolicy: &RestartPolicy{Policy: RestartAlways, Jitter: 0.5}})
+
+	for i := 0; i < 100; i++ {
+		p.status = statusFailed
+		p.failures = 0
+
+		delay, ok := p.backoff(true)
+		if !ok || delay < time.Second/2 || delay > time.Second*3/2 {
+			t.Fatalf("backoff %s %v, want within 500ms and 1.5s", delay, ok)
+		}
+	}
+}
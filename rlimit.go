@@ -0,0 +1,147 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RlimitValue is a resource limit, in text it is written as a number or
+// unlimited.
+type RlimitValue uint64
+
+const RlimitUnlimited = RlimitValue(math.MaxUint64)
+
+// ParseRlimitValue parses a number, or unlimited or infinity in any case.
+func ParseRlimitValue(s string) (RlimitValue, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "unlimited", "infinity":
+		return RlimitUnlimited, nil
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rlimit value: %q", s)
+	}
+
+	return RlimitValue(n), nil
+}
+
+func (v RlimitValue) String() string {
+	if v == RlimitUnlimited {
+		return "unlimited"
+	}
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+func (v RlimitValue) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+func (v *RlimitValue) UnmarshalText(text []byte) error {
+	value, err := ParseRlimitValue(string(text))
+	if err != nil {
+		return err
+	}
+
+	*v = value
+
+	return nil
+}
+
+// UnmarshalJSON takes a plain number besides the text form.
+func (v *RlimitValue) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		err := json.Unmarshal(data, &text)
+		if err != nil {
+			return err
+		}
+		data = []byte(text)
+	}
+
+	return v.UnmarshalText(data)
+}
+
+// Rlimit caps a resource of the process as setrlimit(2) does, Resource is the
+// lower case name without the RLIMIT_ prefix, such as nofile or core.
+type Rlimit struct {
+	Resource string      `json:"resource" yaml:"resource" toml:"resource"`
+	Soft     RlimitValue `json:"soft" yaml:"soft" toml:"soft"`
+	Hard     RlimitValue `json:"hard" yaml:"hard" toml:"hard"`
+}
+
+// ParseRlimit parses resource=soft:hard, or resource=value for equal soft and
+// hard limits.
+func ParseRlimit(s string) (*Rlimit, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid rlimit: %q, want resource=soft:hard", s)
+	}
+
+	soft, hard := s[i+1:], s[i+1:]
+	if j := strings.Index(soft, ":"); j >= 0 {
+		soft, hard = soft[:j], soft[j+1:]
+	}
+
+	r := &Rlimit{Resource: strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s[:i])), "rlimit_")}
+
+	var err error
+	r.Soft, err = ParseRlimitValue(soft)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Hard, err = ParseRlimitValue(hard)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, r.validate()
+}
+
+func (r *Rlimit) String() string {
+	return fmt.Sprintf("%s=%s:%s", r.Resource, r.Soft, r.Hard)
+}
+
+func (r *Rlimit) validate() error {
+	if _, ok := rlimitResources[r.Resource]; !ok {
+		return fmt.Errorf("unknown rlimit resource: %s", r.Resource)
+	}
+	if r.Soft > r.Hard {
+		return fmt.Errorf("invalid rlimit: %s, soft limit above hard limit", r)
+	}
+	return nil
+}
+
+func validateRlimits(limits []*Rlimit) error {
+	seen := make(map[string]bool, len(limits))
+	for _, r := range limits {
+		err := r.validate()
+		if err != nil {
+			return err
+		}
+
+		if seen[r.Resource] {
+			return fmt.Errorf("duplicate rlimit resource: %s", r.Resource)
+		}
+		seen[r.Resource] = true
+	}
+	return nil
+}
+
+func formatRlimits(limits []*Rlimit) []string {
+	s := make([]string, 0, len(limits))
+	for _, r := range limits {
+		s = append(s, r.String())
+	}
+	sort.Strings(s)
+	return s
+}
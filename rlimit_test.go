@@ -0,0 +1,85 @@
+package process
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseRlimit(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		invalid bool
+	}{
+		{"nofile=65536:65536", "nofile=65536:65536", false},
+		{"nofile=1024:65536", "nofile=1024:65536", false},
+		{"nofile=4096", "nofile=4096:4096", false},
+		{"core=unlimited", "core=unlimited:unlimited", false},
+		{"core=0:infinity", "core=0:unlimited", false},
+		{"RLIMIT_NOFILE=1024", "nofile=1024:1024", false},
+		{" nofile = 1024 : 2048 ", "nofile=1024:2048", false},
+		{"nofile", "", true},
+		{"nofile=", "", true},
+		{"nofile=-1", "", true},
+		{"nofile=2048:1024", "", true},
+		{"nofile=unlimited:1024", "", true},
+		{"files=1024", "", true},
+	}
+
+	for _, test := range tests {
+		r, err := ParseRlimit(test.s)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("ParseRlimit(%q) = %s, want an error", test.s, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRlimit(%q): %s", test.s, err)
+			continue
+		}
+		if r.String() != test.want {
+			t.Errorf("ParseRlimit(%q) = %s, want %s", test.s, r, test.want)
+		}
+	}
+}
+
+func TestRlimitJSON(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    string
+		invalid bool
+	}{
+		{`{"resource": "nofile", "soft": 1024, "hard": 4096}`, "nofile=1024:4096", false},
+		{`{"resource": "nofile", "soft": "1024", "hard": "4096"}`, "nofile=1024:4096", false},
+		{`{"resource": "core", "soft": "unlimited", "hard": "unlimited"}`, "core=unlimited:unlimited", false},
+		{`{"resource": "core", "soft": 0, "hard": null}`, "core=0:0", false},
+		{`{"resource": "nofile", "soft": "many", "hard": 4096}`, "", true},
+		{`{"resource": "nofile", "soft": -1, "hard": 4096}`, "", true},
+	}
+
+	for _, test := range tests {
+		var r Rlimit
+		err := json.Unmarshal([]byte(test.data), &r)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: %s, want an error", test.data, &r)
+			}
+			continue
+		}
+		if err != nil || r.String() != test.want {
+			t.Errorf("%s: %s, %v, want %s", test.data, &r, err, test.want)
+			continue
+		}
+
+		// what is written is read back the same.
+		data, err := json.Marshal(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Rlimit
+		if err := json.Unmarshal(data, &back); err != nil || back != r {
+			t.Errorf("%s: round trip through %s gives %s, %v", test.data, data, &back, err)
+		}
+	}
+}
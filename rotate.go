@@ -0,0 +1,232 @@
+package process
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotateTimeFormat = "20060102-150405.000"
+
+// Rotation moves an output file aside once it grows past MaxSize or gets
+// older than Interval, keeping at most MaxBackups rotated segments.
+type Rotation struct {
+	MaxSize    Size          `json:"max_size" yaml:"max_size" toml:"max_size"`
+	Interval   time.Duration `json:"interval" yaml:"interval" toml:"interval"`
+	MaxBackups int           `json:"max_backups" yaml:"max_backups" toml:"max_backups"`
+	Compress   bool          `json:"compress" yaml:"compress" toml:"compress"`
+}
+
+func (r *Rotation) String() string {
+	if r == nil {
+		return ""
+	}
+	return fmt.Sprintf("max_size=%s interval=%s max_backups=%d compress=%t", r.MaxSize, r.Interval, r.MaxBackups, r.Compress)
+}
+
+func (r *Rotation) validate() error {
+	if r.MaxSize < 0 || r.Interval < 0 || r.MaxBackups < 0 {
+		return fmt.Errorf("invalid rotation: %+v", *r)
+	}
+	return nil
+}
+
+// rotateWriter writes whole lines to a file and rotates it between writes,
+// so no line is split or lost across segments.
+type rotateWriter struct {
+	m        sync.Mutex
+	cleanup  sync.Mutex
+	path     string
+	file     *os.File
+	regular  bool
+	size     int64
+	opened   time.Time
+	rotation *Rotation
+}
+
+func newRotateWriter(path string, file *os.File, rotation *Rotation) *rotateWriter {
+	w := &rotateWriter{
+		path:     path,
+		file:     file,
+		opened:   time.Now(),
+		rotation: rotation,
+	}
+
+	info, err := file.Stat()
+	if err == nil {
+		w.regular = info.Mode().IsRegular()
+		w.size = info.Size()
+		w.opened = info.ModTime()
+	}
+
+	return w
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.due(int64(len(p))) {
+		err := w.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+func (w *rotateWriter) due(n int64) bool {
+	if w.rotation == nil || !w.regular {
+		return false
+	}
+
+	if w.rotation.MaxSize > 0 && w.size > 0 && w.size+n > int64(w.rotation.MaxSize) {
+		return true
+	}
+
+	return w.rotation.Interval > 0 && time.Since(w.opened) >= w.rotation.Interval
+}
+
+// Rotate forces a rotation.
+func (w *rotateWriter) Rotate() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+
+	// devices such as /dev/null are never rotated.
+	if !w.regular {
+		return nil
+	}
+
+	return w.rotate()
+}
+
+// rotate renames the file while it is still open, so a failure at any step
+// leaves a file to write to.
+func (w *rotateWriter) rotate() error {
+	backup := w.path + "." + time.Now().Format(rotateTimeFormat)
+	for i := 1; ; i++ {
+		_, err := os.Stat(backup)
+		if os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%s-%d", w.path, time.Now().Format(rotateTimeFormat), i)
+	}
+
+	err := os.Rename(w.path, backup)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	_ = w.file.Close()
+
+	w.file = file
+	w.size = 0
+	w.opened = time.Now()
+
+	go w.clean(backup)
+
+	return nil
+}
+
+// clean compresses a fresh backup and drops the backups beyond MaxBackups.
+func (w *rotateWriter) clean(backup string) {
+	w.cleanup.Lock()
+	defer w.cleanup.Unlock()
+
+	if w.rotation != nil && w.rotation.Compress {
+		_ = compress(backup)
+	}
+
+	if w.rotation == nil || w.rotation.MaxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return
+	}
+
+	filtered := backups[:0]
+	for _, b := range backups {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(b, w.path+"."), ".gz")
+		if len(stamp) > len(rotateTimeFormat) {
+			stamp = stamp[:len(rotateTimeFormat)]
+		}
+		if _, err := time.Parse(rotateTimeFormat, stamp); err == nil {
+			filtered = append(filtered, b)
+		}
+	}
+
+	sort.Strings(filtered)
+
+	for len(filtered) > w.rotation.MaxBackups {
+		_ = os.Remove(filtered[0])
+		filtered = filtered[1:]
+	}
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (w *rotateWriter) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	return err
+}
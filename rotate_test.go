@@ -0,0 +1,131 @@
+package process
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rotation *Rotation
+		writes   []string
+		rotate   int
+		backups  int
+		suffix   string
+		current  string
+	}{
+		{"no rotation", nil, []string{"a\n", "b\n"}, 0, 0, "", "a\nb\n"},
+		{"below the size", &Rotation{MaxSize: 100}, []string{"a\n", "b\n"}, 0, 0, "", "a\nb\n"},
+		{"past the size", &Rotation{MaxSize: 10}, []string{"1234567\n", "1234567\n", "1234567\n"}, 0, 2, "", "1234567\n"},
+		{"a line longer than the size", &Rotation{MaxSize: 2}, []string{"1234567\n"}, 0, 0, "", "1234567\n"},
+		{"forced", &Rotation{}, []string{"a\n"}, 1, 1, "", ""},
+		{"backups pruned", &Rotation{MaxBackups: 2}, []string{"a\n"}, 5, 2, "", ""},
+		{"compressed", &Rotation{MaxBackups: 1, Compress: true}, []string{"a\n"}, 3, 1, ".gz", ""},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "out.log")
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := newRotateWriter(path, file, test.rotation)
+
+		for _, line := range test.writes {
+			if _, err := w.Write([]byte(line)); err != nil {
+				t.Fatalf("%s: write: %s", test.name, err)
+			}
+		}
+		for i := 0; i < test.rotate; i++ {
+			if err := w.Rotate(); err != nil {
+				t.Fatalf("%s: rotate: %s", test.name, err)
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// backups are compressed and pruned in the background.
+		var backups []string
+		for deadline := time.Now().Add(time.Second * 5); ; time.Sleep(time.Millisecond * 10) {
+			backups, _ = filepath.Glob(path + ".*")
+			if len(backups) == test.backups && allSuffixed(backups, test.suffix) || time.Now().After(deadline) {
+				break
+			}
+		}
+
+		if len(backups) != test.backups || !allSuffixed(backups, test.suffix) {
+			t.Errorf("%s: backups %v, want %d ending in %q", test.name, backups, test.backups, test.suffix)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.current {
+			t.Errorf("%s: current file %q, want %q", test.name, data, test.current)
+		}
+	}
+}
+
+func TestRotateWriterInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newRotateWriter(path, file, &Rotation{Interval: time.Millisecond * 50})
+	defer w.Close()
+
+	_, _ = w.Write([]byte("a\n"))
+	time.Sleep(time.Millisecond * 100)
+	_, _ = w.Write([]byte("b\n"))
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("backups %v, want one", backups)
+	}
+
+	data, _ := ioutil.ReadFile(backups[0])
+	if string(data) != "a\n" {
+		t.Errorf("backup %q, want %q", data, "a\n")
+	}
+}
+
+func TestRotateWriterDevice(t *testing.T) {
+	file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newRotateWriter(os.DevNull, file, &Rotation{MaxSize: 1})
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func allSuffixed(paths []string, suffix string) bool {
+	for _, path := range paths {
+		if !strings.HasSuffix(path, suffix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package process
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorExists    = "exists"
+	selectorNotExists = "!exists"
+)
+
+type requirement struct {
+	key      string
+	operator string
+	value    string
+}
+
+// Selector matches labels, it is parsed from a comma separated list of
+// requirements: key=value, key==value, key!=value, key and !key.
+type Selector []requirement
+
+// ParseSelector parses a selector, an empty string is the selector that
+// matches everything.
+func ParseSelector(s string) (Selector, error) {
+	var selector Selector
+
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var r requirement
+
+		switch {
+		case strings.Contains(term, "!="):
+			i := strings.Index(term, "!=")
+			r = requirement{key: term[:i], operator: selectorNotEquals, value: term[i+2:]}
+		case strings.Contains(term, "=="):
+			i := strings.Index(term, "==")
+			r = requirement{key: term[:i], operator: selectorEquals, value: term[i+2:]}
+		case strings.Contains(term, "="):
+			i := strings.Index(term, "=")
+			r = requirement{key: term[:i], operator: selectorEquals, value: term[i+1:]}
+		case strings.HasPrefix(term, "!"):
+			r = requirement{key: term[1:], operator: selectorNotExists}
+		default:
+			r = requirement{key: term, operator: selectorExists}
+		}
+
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+
+		err := validateLabel(r.key, r.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", term, err)
+		}
+
+		selector = append(selector, r)
+	}
+
+	// an empty selector matches everything, one that only looks empty is a
+	// mistake.
+	if selector == nil && s != "" {
+		return nil, fmt.Errorf("invalid selector %q: no requirements", s)
+	}
+
+	return selector, nil
+}
+
+// Matches reports whether labels satisfy every requirement, an empty selector
+// matches everything.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, ok := labels[r.key]
+
+		switch r.operator {
+		case selectorEquals:
+			if !ok || value != r.value {
+				return false
+			}
+		case selectorNotEquals:
+			if ok && value == r.value {
+				return false
+			}
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	terms := make([]string, 0, len(s))
+	for _, r := range s {
+		switch r.operator {
+		case selectorExists:
+			terms = append(terms, r.key)
+		case selectorNotExists:
+			terms = append(terms, "!"+r.key)
+		default:
+			terms = append(terms, r.key+r.operator+r.value)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+func validateLabel(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("empty label key")
+	}
+	if strings.ContainsAny(key, ",=! \t\r\n") {
+		return fmt.Errorf("invalid label key: %q", key)
+	}
+	if strings.ContainsAny(value, ",=! \t\r\n") {
+		return fmt.Errorf("invalid label value: %q", value)
+	}
+	return nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		err := validateLabel(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatLabels(labels map[string]string) []string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
@@ -0,0 +1,73 @@
+package process
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		invalid bool
+	}{
+		{"", "", false},
+		{"env=prod", "env=prod", false},
+		{"env==prod", "env=prod", false},
+		{" env = prod , tier!=batch ", "env=prod,tier!=batch", false},
+		{"canary,!legacy", "canary,!legacy", false},
+		{",", "", true},
+		{" , ", "", true},
+		{"=prod", "", true},
+		{"env=prod=eu", "", true},
+		{"env=pr od", "", true},
+		{"!", "", true},
+	}
+
+	for _, test := range tests {
+		selector, err := ParseSelector(test.s)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("ParseSelector(%q) = %q, want an error", test.s, selector)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %s", test.s, err)
+			continue
+		}
+		if selector.String() != test.want {
+			t.Errorf("ParseSelector(%q) = %q, want %q", test.s, selector, test.want)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "tier": "web", "canary": ""}
+
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"region=eu", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"region!=eu", true},
+		{"canary", true},
+		{"region", false},
+		{"!region", true},
+		{"!canary", false},
+		{"env=prod,tier=web", true},
+		{"env=prod,tier=batch", false},
+	}
+
+	for _, test := range tests {
+		selector, err := ParseSelector(test.s)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q): %s", test.s, err)
+		}
+		if got := selector.Matches(labels); got != test.want {
+			t.Errorf("%q matches %v = %v, want %v", test.s, labels, got, test.want)
+		}
+	}
+}
@@ -1,25 +1,234 @@
 package process
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
+// operateTimeout bounds an operation, stop and restart add the gracefully
+// period on top of it.
+const operateTimeout = time.Second * 10
+
 type RPC struct {
-	manager *Manager
+	manager   *Manager
+	peerRules []*PeerRule
+	policy    *Policy
+	audit     *Audit
+
+	// peer is the caller of the connection the methods serve, set on the
+	// copy made for every connection along with its policy subjects.
+	peer     *Peer
+	subjects []string
+}
+
+type RPCOption func(r *RPC)
+
+// WithPeerRules grants unix socket peers other than root and the user of the
+// service the operations of the matching rules, they may run none without.
+func WithPeerRules(rules []*PeerRule) RPCOption {
+	return func(r *RPC) {
+		r.peerRules = rules
+	}
 }
 
-func NewRPC(manager *Manager) *RPC {
-	return &RPC{
+// WithPolicy has the policy decide what every caller but root and the user of
+// the service may do, in place of the peer rules.
+func WithPolicy(policy *Policy) RPCOption {
+	return func(r *RPC) {
+		r.policy = policy
+	}
+}
+
+// WithAudit records every call in the audit log.
+func WithAudit(audit *Audit) RPCOption {
+	return func(r *RPC) {
+		r.audit = audit
+	}
+}
+
+func NewRPC(manager *Manager, options ...RPCOption) *RPC {
+	r := &RPC{
 		manager: manager,
 	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+func (r *RPC) session(peer *Peer) *RPC {
+	s := *r
+	s.peer = peer
+	if r.policy != nil && peer != nil {
+		s.subjects = peer.subjects()
+	}
+	return &s
+}
+
+// privileged tells whether the caller may do anything: the manager itself,
+// and root and the user of the service over the unix socket.
+func (r *RPC) privileged() bool {
+	if r.peer == nil {
+		return true
+	}
+
+	c := r.peer.Credential
+	return c != nil && (c.UID == 0 || c.UID == os.Getuid())
+}
+
+// authorize checks the caller may run the operation on some process. Without
+// a policy that only restricts unix socket peers, a tcp caller is
+// authenticated by the handshake.
+func (r *RPC) authorize(operation string) error {
+	if r.privileged() {
+		return nil
+	}
+
+	if r.policy != nil {
+		if len(r.policy.grants(r.subjects, operation)) == 0 {
+			return fmt.Errorf("%w: %s may not %s", errPermissionDenied, r.peer, operation)
+		}
+		return nil
+	}
+
+	c := r.peer.Credential
+	if c == nil {
+		return nil
+	}
+
+	gids := peerGroups(c)
+	for _, rule := range r.peerRules {
+		if rule.grants(c.UID, gids, operation) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s may not %s", errPermissionDenied, r.peer, operation)
+}
+
+func (r *RPC) permits(operation, name string, labels map[string]string) bool {
+	if r.policy == nil || r.privileged() {
+		return true
+	}
+
+	for _, g := range r.policy.grants(r.subjects, operation) {
+		if g.matches(name, labels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizeRun checks the caller may start a process of the name and labels
+// running cmd, and the command of an exec health check, with env as user,
+// group and groups. Without a policy, a unix socket peer may only start
+// processes as its own user.
+func (r *RPC) authorizeRun(operation, name string, labels map[string]string, cmd string, check *HealthCheck, env []string, user, group string, groups []string) error {
+	if r.privileged() {
+		return nil
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	if user != "" {
+		u, err := lookupUser(user)
+		if err != nil {
+			return err
+		}
+		uid = u.Uid
+	}
+
+	var gids []string
+	for _, group := range append([]string{group}, groups...) {
+		if group == "" {
+			continue
+		}
+		gid, err := lookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gids = append(gids, strconv.FormatUint(uint64(gid), 10))
+	}
+
+	if r.policy == nil {
+		c := r.peer.Credential
+		if c == nil || uid == strconv.Itoa(c.UID) && allowedGroups(gids, peerGroups(c)) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s may not %s %s running %s as uid %s", errPermissionDenied, r.peer, operation, name, cmd, uid)
+	}
+
+	// the check runs as the process does.
+	cmds := []string{cmd}
+	if check != nil && check.Type == HealthExec && len(check.Exec) > 0 {
+		cmds = append(cmds, check.Exec[0])
+	}
+
+	grants := r.policy.grants(r.subjects, operation)
+
+	for _, cmd := range cmds {
+		allowed := false
+		for _, g := range grants {
+			if g.matches(name, labels) && g.runs(cmd, env, uid, gids) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s may not %s %s running %s as uid %s", errPermissionDenied, r.peer, operation, name, cmd, uid)
+		}
+	}
+
+	return nil
+}
+
+func allowedGroups(gids []string, allowed []int) bool {
+	for _, gid := range gids {
+		id, _ := strconv.Atoi(gid)
+		if !containsInt(allowed, id) {
+			return false
+		}
+	}
+	return true
+}
+
+// authorizeProcess checks the caller may run the operation on the process
+// given by id, a process that is not found is left to the operation to report.
+func (r *RPC) authorizeProcess(operation, id string) error {
+	err := r.authorize(operation)
+	if err != nil || r.policy == nil || r.privileged() {
+		return err
+	}
+
+	process, err := r.manager.searchProcess(id)
+	if err != nil {
+		return nil
+	}
+
+	process.m.Lock()
+	name, labels := process.attributes.name, process.attributes.labels
+	process.m.Unlock()
+
+	if !r.permits(operation, name, labels) {
+		return fmt.Errorf("%w: %s may not %s %s", errPermissionDenied, r.peer, operation, name)
+	}
+
+	return nil
 }
 
 type ListArgv struct {
-	Cmd    string
-	Status string
+	Cmd      string
+	Status   string
+	Selector string
 }
 
 type ListReply struct {
@@ -27,13 +236,30 @@ type ListReply struct {
 }
 
 func (r *RPC) List(argv *ListArgv, reply *ListReply) error {
-	metadata := r.manager.List()
+	err := r.authorize("list")
+	if err != nil {
+		return err
+	}
+
+	metadata, err := r.filter(argv.Selector, argv.Cmd, argv.Status)
+	if err != nil {
+		return err
+	}
 
 	for _, m := range metadata {
-		reply.Metadata = append(reply.Metadata, map[string]string{
+		if !r.permits("list", m.Name, m.Labels) {
+			continue
+		}
+
+		row := map[string]string{
 			"UUID":     fmt.Sprint(m.UUID),
+			"Name":     fmt.Sprint(m.Name),
+			"Labels":   strings.Join(formatLabels(m.Labels), "\n"),
+			"Status":   fmt.Sprint(m.Status),
+			"Since":    formatTime(m.Since),
 			"Pid":      fmt.Sprint(m.Pid),
 			"Alive":    fmt.Sprint(m.Alive),
+			"Adopted":  fmt.Sprint(m.Adopted),
 			"Dir":      fmt.Sprint(m.Dir),
 			"Cmd":      fmt.Sprint(m.Cmd),
 			"Argv":     strings.Join(m.Argv, "\n"),
@@ -41,75 +267,590 @@ func (r *RPC) List(argv *ListArgv, reply *ListReply) error {
 			"Files":    strings.Join(m.Files, "\n"),
 			"Restart":  fmt.Sprint(m.Restart),
 			"Cron":     fmt.Sprint(m.Cron),
+			"Capture":  fmt.Sprint(m.Capture),
+			"Rotation": m.Rotation.String(),
 			"Events":   strings.Join(m.Events, "\n"),
+
 			"ExitCode": fmt.Sprint(m.ExitCode),
 			"ExitData": fmt.Sprint(m.ExitData),
-		})
+
+			"RestartPolicy": m.RestartPolicy.String(),
+			"Restarts":      fmt.Sprint(m.Restarts),
+			"NextRetry":     formatTime(m.NextRetry),
+			"HealthCheck":   m.HealthCheck.String(),
+			"Health":        m.Health,
+			"Readiness":     m.Readiness.String(),
+			"Ready":         fmt.Sprint(m.Ready),
+			"DependsOn":     strings.Join(formatDependencies(m.DependsOn), "\n"),
+			"KillMode":      m.KillMode,
+			"Session":       fmt.Sprint(m.Session),
+			"User":          m.User,
+			"Group":         m.Group,
+			"Groups":        strings.Join(m.Groups, "\n"),
+			"Limits":        strings.Join(formatRlimits(m.Limits), "\n"),
+
+			"Cgroup":        m.Cgroup.String(),
+			"CgroupPath":    m.CgroupPath,
+			"MemoryCurrent": m.MemoryCurrent.String(),
+			"CPUUsage":      m.CPUUsage.String(),
+			"CPUThrottled":  m.CPUThrottled.String(),
+			"OOMKills":      fmt.Sprint(m.OOMKills),
+
+			"CPU":        "",
+			"RSS":        "",
+			"Threads":    "",
+			"FDs":        "",
+			"ReadBytes":  "",
+			"WriteBytes": "",
+			"Uptime":     formatUptime(m.Uptime),
+
+			"CronRuns":     fmt.Sprint(m.CronRuns),
+			"CronFailures": fmt.Sprint(m.CronFailures),
+
+			"MaxMemoryRestart": "",
+			"MaxUptime":        "",
+			"Recycles":         strings.Join(formatRecycles(m.Recycles), "\n"),
+			"HealthRestarts":   fmt.Sprint(m.HealthRestarts),
+		}
+
+		if m.MaxMemoryRestart > 0 {
+			row["MaxMemoryRestart"] = m.MaxMemoryRestart.String()
+		}
+		if m.MaxUptime > 0 {
+			row["MaxUptime"] = m.MaxUptime.String()
+		}
+
+		if m.Stats != nil {
+			row["CPU"] = fmt.Sprintf("%.1f", m.Stats.CPU)
+			row["RSS"] = formatBytes(m.Stats.RSS)
+			row["Threads"] = fmt.Sprint(m.Stats.Threads)
+			row["FDs"] = fmt.Sprint(m.Stats.FDs)
+			row["ReadBytes"] = formatBytes(m.Stats.ReadBytes)
+			row["WriteBytes"] = formatBytes(m.Stats.WriteBytes)
+		}
+
+		reply.Metadata = append(reply.Metadata, row)
 	}
 	return nil
 }
 
+// filter returns the metadata of the processes matching the label selector,
+// the command, given as a path or a base name, and the status.
+func (r *RPC) filter(selector, cmd, status string) ([]*Metadata, error) {
+	s, err := ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != "" && !validStatus(status) {
+		return nil, fmt.Errorf("unknown status: %s", status)
+	}
+
+	metadata := r.manager.List()
+
+	filtered := make([]*Metadata, 0, len(metadata))
+	for _, m := range metadata {
+		if !s.Matches(m.Labels) {
+			continue
+		}
+		if cmd != "" && m.Cmd != cmd && filepath.Base(m.Cmd) != cmd {
+			continue
+		}
+		if status != "" && m.Status != status {
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].Name != filtered[j].Name {
+			return filtered[i].Name < filtered[j].Name
+		}
+		return filtered[i].UUID < filtered[j].UUID
+	})
+
+	return filtered, nil
+}
+
+// formatBytes rounds a size to one decimal of the largest binary unit below
+// it, ParseSize reads it back.
+func formatBytes(s Size) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	n := float64(s)
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%dB", int64(s))
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}
+
+func formatUptime(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.Round(time.Second).String()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+type Result struct {
+	UUID  string
+	Name  string
+	Pid   int
+	Error string
+}
+
+// operate runs an operation on the manager, timeout is the deadline for the
+// whole operation including the time spent in the queue.
+func (r *RPC) operate(timeout time.Duration, operate interface{}) (*Outcome, error) {
+	ctx, cancel := context.WithTimeout(WithPeer(context.Background(), r.peer), timeout)
+	defer cancel()
+
+	return r.manager.Operate(ctx, operate)
+}
+
+// each runs the operation on the process given by id, or on every process
+// matching the selector, reporting the outcome per process. Processes are
+// taken in dependency order, dependents first when reverse is set.
+func (r *RPC) each(operation, id, selector string, timeout time.Duration, reverse bool, operate func(id string) interface{}) ([]*Result, error) {
+	if selector == "" {
+		err := r.authorizeProcess(operation, id)
+		if err != nil {
+			return nil, err
+		}
+
+		outcome, err := r.operate(timeout, operate(id))
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Result{{UUID: outcome.UUID, Name: outcome.Name, Pid: outcome.Pid}}, nil
+	}
+
+	if id != "" {
+		return nil, fmt.Errorf("only one of id and selector allowed")
+	}
+
+	err := r.authorize(operation)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := r.filter(selector, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(metadata))
+	for i, m := range metadata {
+		names[i] = m.Name
+	}
+
+	r.manager.dependencyOrder(names, reverse)
+
+	order := make(map[string]int, len(names))
+	for i, name := range names {
+		order[name] = i
+	}
+
+	sort.SliceStable(metadata, func(i, j int) bool {
+		return order[metadata[i].Name] < order[metadata[j].Name]
+	})
+
+	results := make([]*Result, 0, len(metadata))
+	for _, m := range metadata {
+		result := &Result{
+			UUID: m.UUID,
+			Name: m.Name,
+			Pid:  m.Pid,
+		}
+
+		if !r.permits(operation, m.Name, m.Labels) {
+			result.Error = fmt.Sprintf("%s: %s may not %s %s", errPermissionDenied, r.peer, operation, m.Name)
+			results = append(results, result)
+			continue
+		}
+
+		outcome, err := r.operate(timeout, operate(m.UUID))
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Pid = outcome.Pid
+		}
+
+		results = append(results, result)
+	}
+	return results, nil
+}
+
 type StartArgv struct {
-	Dir     string
-	Cmd     string
-	Argv    []string
-	Env     []string
-	Files   []string
-	Restart bool
-	Cron    string
+	Name     string
+	Dir      string
+	Cmd      string
+	Argv     []string
+	Env      []string
+	Files    []string
+	Restart  bool
+	Cron     string
+	Labels   map[string]string
+	Capture  bool
+	Rotation *Rotation
+
+	RestartPolicy *RestartPolicy
+	HealthCheck   *HealthCheck
+	Readiness     *Readiness
+	DependsOn     []*Dependency
+	KillMode      string
+	Session       bool
+	User          string
+	Group         string
+	Groups        []string
+	Limits        []*Rlimit
+	Cgroup        *Cgroup
+
+	MaxMemoryRestart Size
+	MaxUptime        time.Duration
 }
 
 type StartReply struct {
+	UUID string
+	Name string
+	Pid  int
 }
 
 func (r *RPC) Start(argv *StartArgv, reply *StartReply) error {
-	return r.manager.Operate(newOperateStart(argv.Dir, argv.Cmd, argv.Argv, argv.Env, argv.Files, argv.Restart, argv.Cron), time.Second*10)
+	err := r.authorize("start")
+	if err != nil {
+		return err
+	}
+
+	err = r.authorizeRun("start", argv.Name, argv.Labels, argv.Cmd, argv.HealthCheck, argv.Env, argv.User, argv.Group, argv.Groups)
+	if err != nil {
+		return err
+	}
+
+	// the fields of StartArgv are those of OperateStart.
+	start := OperateStart(*argv)
+
+	outcome, err := r.operate(operateTimeout, &start)
+	if err != nil {
+		return err
+	}
+
+	reply.UUID = outcome.UUID
+	reply.Name = outcome.Name
+	reply.Pid = outcome.Pid
+
+	return nil
+}
+
+// argvID returns the process an argv names, by ID or by UUID, the field
+// clients used before processes had names.
+func argvID(id, uuid string) (string, error) {
+	if id != "" && uuid != "" && id != uuid {
+		return "", fmt.Errorf("only one of id and uuid allowed")
+	}
+	if id == "" {
+		return uuid, nil
+	}
+	return id, nil
 }
 
 type KillArgv struct {
-	UUID  string
-	Prune bool
+	ID       string
+	UUID     string
+	Selector string
+	Prune    bool
 }
 
 type KillReply struct {
+	Results []*Result
 }
 
 func (r *RPC) Kill(argv *KillArgv, reply *KillReply) error {
-	return r.manager.Operate(newOperateKill(argv.UUID, argv.Prune), time.Second*10)
+	id, err := argvID(argv.ID, argv.UUID)
+	if err != nil {
+		return err
+	}
+
+	results, err := r.each("kill", id, argv.Selector, operateTimeout, true, func(id string) interface{} {
+		return newOperateKill(id, argv.Prune)
+	})
+	reply.Results = results
+	return err
 }
 
 type StopArgv struct {
+	ID         string
 	UUID       string
+	Selector   string
 	Gracefully time.Duration
 	Prune      bool
 }
 
 type StopReply struct {
+	Results []*Result
 }
 
 func (r *RPC) Stop(argv *StopArgv, reply *StopReply) error {
-	return r.manager.Operate(newOperateStop(argv.UUID, argv.Gracefully, argv.Prune), time.Second*10)
+	id, err := argvID(argv.ID, argv.UUID)
+	if err != nil {
+		return err
+	}
+
+	results, err := r.each("stop", id, argv.Selector, operateTimeout+argv.Gracefully, true, func(id string) interface{} {
+		return newOperateStop(id, argv.Gracefully, argv.Prune)
+	})
+	reply.Results = results
+	return err
 }
 
 type RestartArgv struct {
+	ID         string
 	UUID       string
+	Selector   string
 	Gracefully time.Duration
+	Cascade    bool
 }
 
 type RestartReply struct {
+	Results []*Result
 }
 
 func (r *RPC) Restart(argv *RestartArgv, reply *RestartReply) error {
-	return r.manager.Operate(newOperateRestart(argv.UUID, argv.Gracefully), time.Second*10)
+	id, err := argvID(argv.ID, argv.UUID)
+	if err != nil {
+		return err
+	}
+
+	results, err := r.each("restart", id, argv.Selector, operateTimeout+argv.Gracefully, false, func(id string) interface{} {
+		return newOperateRestart(id, argv.Gracefully, argv.Cascade)
+	})
+	reply.Results = results
+	return err
 }
 
 type SignalArgv struct {
-	UUID   string
-	Signal syscall.Signal
+	ID       string
+	UUID     string
+	Selector string
+	Signal   syscall.Signal
 }
 
-type SignalReply struct{}
+type SignalReply struct {
+	Results []*Result
+}
 
 func (r *RPC) Signal(argv *SignalArgv, reply *SignalReply) error {
-	return r.manager.Operate(newOperateSignal(argv.UUID, argv.Signal), time.Second*10)
+	id, err := argvID(argv.ID, argv.UUID)
+	if err != nil {
+		return err
+	}
+
+	results, err := r.each("signal", id, argv.Selector, operateTimeout, false, func(id string) interface{} {
+		return newOperateSignal(id, argv.Signal)
+	})
+	reply.Results = results
+	return err
+}
+
+type ApplyArgv struct {
+	Source     string
+	Processes  []*EcosystemProcess
+	Delete     bool
+	DryRun     bool
+	Force      bool
+	Gracefully time.Duration
+}
+
+type ApplyReply struct {
+	Changes []*Change
+}
+
+func (r *RPC) Apply(argv *ApplyArgv, reply *ApplyReply) error {
+	err := r.authorize("apply")
+	if err != nil {
+		return err
+	}
+
+	// an apply may change every process of the source besides those given.
+	for _, process := range argv.Processes {
+		err = r.authorizeRun("apply", process.Name, process.Labels, process.Cmd, process.HealthCheck, process.Env, process.User, process.Group, process.Groups)
+		if err != nil {
+			return err
+		}
+	}
+	for _, process := range r.manager.searchSource(argv.Source) {
+		process.m.Lock()
+		name, labels := process.attributes.name, process.attributes.labels
+		process.m.Unlock()
+
+		if !r.permits("apply", name, labels) {
+			return fmt.Errorf("%w: %s may not apply %s", errPermissionDenied, r.peer, name)
+		}
+	}
+
+	outcome, err := r.operate(time.Minute, newOperateApply(argv.Source, argv.Processes, argv.Delete, argv.DryRun, argv.Force, argv.Gracefully))
+	if outcome != nil {
+		reply.Changes = outcome.Changes
+	}
+	return err
+}
+
+// logsWait caps how long a single Logs call blocks, following clients call
+// again with the returned cursor.
+const logsWait = time.Second * 30
+
+type LogsArgv struct {
+	ID     string
+	Lines  int
+	Stdout bool
+	Stderr bool
+	Cursor uint64
+	Wait   time.Duration
+}
+
+type LogsReply struct {
+	Lines  []*LogLine
+	Cursor uint64
+}
+
+func (r *RPC) Logs(argv *LogsArgv, reply *LogsReply) error {
+	err := r.authorizeProcess("logs", argv.ID)
+	if err != nil {
+		return err
+	}
+
+	streams := map[string]bool{
+		streamStdout: argv.Stdout || !argv.Stderr,
+		streamStderr: argv.Stderr || !argv.Stdout,
+	}
+
+	wait := argv.Wait
+	if wait > logsWait {
+		wait = logsWait
+	}
+
+	lines, cursor, err := r.manager.Logs(argv.ID, argv.Cursor, argv.Lines, streams, wait)
+	if err != nil {
+		return err
+	}
+
+	reply.Lines = lines
+	reply.Cursor = cursor
+
+	return nil
+}
+
+type RotateArgv struct {
+	ID string
+}
+
+type RotateReply struct{}
+
+func (r *RPC) Rotate(argv *RotateArgv, reply *RotateReply) error {
+	err := r.authorizeProcess("rotate", argv.ID)
+	if err != nil {
+		return err
+	}
+
+	return r.manager.Rotate(argv.ID)
+}
+
+const waitTimeout = time.Second * 30
+
+type WaitArgv struct {
+	ID      string
+	For     string
+	Timeout time.Duration
+}
+
+type WaitReply struct {
+	UUID   string
+	Name   string
+	Pid    int
+	Status string
+	Ready  bool
+}
+
+func (r *RPC) Wait(argv *WaitArgv, reply *WaitReply) error {
+	err := r.authorizeProcess("wait", argv.ID)
+	if err != nil {
+		return err
+	}
+
+	timeout := argv.Timeout
+	if timeout <= 0 {
+		timeout = waitTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	m, err := r.manager.Wait(ctx, argv.ID, argv.For)
+	if err != nil {
+		return err
+	}
+
+	reply.UUID = m.UUID
+	reply.Name = m.Name
+	reply.Pid = m.Pid
+	reply.Status = m.Status
+	reply.Ready = m.Ready
+
+	return nil
+}
+
+type AuditArgv struct {
+	Since     time.Time
+	Until     time.Time
+	Process   string
+	Operation string
+	Limit     int
+}
+
+type AuditReply struct {
+	Entries []*AuditEntry
+}
+
+func (r *RPC) Audit(argv *AuditArgv, reply *AuditReply) error {
+	err := r.authorize("audit")
+	if err != nil {
+		return err
+	}
+
+	// the log covers every process, so does the grant to read it.
+	if r.policy != nil && !r.privileged() && !r.policy.unrestricted(r.subjects, "audit") {
+		return fmt.Errorf("%w: %s may not audit every process", errPermissionDenied, r.peer)
+	}
+
+	if r.audit == nil {
+		return fmt.Errorf("audit log not enabled")
+	}
+
+	entries, err := r.audit.Query(&AuditQuery{
+		Since:     argv.Since,
+		Until:     argv.Until,
+		Process:   argv.Process,
+		Operation: argv.Operation,
+		Limit:     argv.Limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	reply.Entries = entries
+
+	return nil
 }
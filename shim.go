@@ -0,0 +1,186 @@
+package process
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// shimEnv hands a shimSpec to a re-executed manager binary, which applies it
+// and executes the command in place, see RunShim.
+const shimEnv = "PROCESS_SHIM"
+
+// shimReady is set by RunShim, the manager only starts a binary that runs it
+// as the shim.
+var shimReady bool
+
+// shimSpec is what the shim applies before it executes Cmd. Status is the
+// descriptor the shim reports a failure on, it is closed on a successful exec.
+type shimSpec struct {
+	Cmd        string
+	Cgroup     string
+	Limits     []*Rlimit
+	Credential *syscall.Credential
+	Status     int
+}
+
+// startProcess starts the command. Go can not join a cgroup or set resource
+// limits between fork and exec, so for those the manager binary is started
+// instead, it joins the cgroup, applies the limits, drops to the credential
+// and executes the command in place, keeping the pid. Nothing the command
+// forks escapes the cgroup that way.
+func (a *Attributes) startProcess(id *identity, cgroup string, env []string, files []*os.File) (*os.Process, error) {
+	return a.startCommand(a.cmd, a.argv, id, cgroup, env, files)
+}
+
+// startCommand starts cmd with argv like the command of the process, in its
+// dir, cgroup and process group, with its limits and credential.
+func (a *Attributes) startCommand(cmd string, argv []string, id *identity, cgroup string, env []string, files []*os.File) (*os.Process, error) {
+	if len(a.limits) == 0 && cgroup == "" {
+		return os.StartProcess(cmd, argv, &os.ProcAttr{
+			Dir:   a.dir,
+			Env:   env,
+			Files: files,
+			Sys:   a.sysProcAttr(id),
+		})
+	}
+
+	if !shimReady {
+		return nil, fmt.Errorf("resource limits and cgroups need process.RunShim in main")
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("find shim: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	spec := &shimSpec{
+		Cmd:    cmd,
+		Cgroup: cgroup,
+		Limits: a.limits,
+		Status: len(files),
+	}
+
+	// the shim needs the privileges of the manager to raise hard limits, it
+	// drops them itself.
+	if id != nil {
+		spec.Credential = id.credential
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+
+	process, err := os.StartProcess(self, argv, &os.ProcAttr{
+		Dir:   a.dir,
+		Env:   setEnv(env, shimEnv, string(data)),
+		Files: append(files[:len(files):len(files)], w),
+		Sys:   a.sysProcAttr(nil),
+	})
+	_ = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	status, _ := ioutil.ReadAll(r)
+	if len(status) > 0 {
+		_, _ = process.Wait()
+		return nil, errors.New(string(status))
+	}
+
+	return process, nil
+}
+
+// RunShim has to be called first thing in main by a binary that runs a Manager
+// with resource limits or cgroups. In the child the manager starts to apply
+// them it executes the command in place and never returns.
+func RunShim() {
+	shimReady = true
+
+	spec, ok := os.LookupEnv(shimEnv)
+	if ok {
+		shim(spec)
+	}
+}
+
+func shim(data string) {
+	runtime.LockOSThread()
+
+	spec := &shimSpec{}
+	err := json.Unmarshal([]byte(data), spec)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "process shim: %s\n", err)
+		os.Exit(127)
+	}
+
+	status := os.NewFile(uintptr(spec.Status), "status")
+	syscall.CloseOnExec(spec.Status)
+
+	fail := func(format string, a ...interface{}) {
+		_, _ = fmt.Fprintf(status, format, a...)
+		os.Exit(127)
+	}
+
+	if spec.Cgroup != "" {
+		err = writeCgroupFile(spec.Cgroup, "cgroup.procs", strconv.Itoa(os.Getpid()))
+		if err != nil {
+			fail("join cgroup %s: %s", spec.Cgroup, err)
+		}
+	}
+
+	for _, r := range spec.Limits {
+		// syscall.Setrlimit, unlike unix.Setrlimit, keeps the runtime from
+		// restoring its own nofile limit on exec.
+		err = syscall.Setrlimit(rlimitResources[r.Resource], &syscall.Rlimit{Cur: uint64(r.Soft), Max: uint64(r.Hard)})
+		if err != nil {
+			fail("set rlimit %s: %s", r, err)
+		}
+	}
+
+	if c := spec.Credential; c != nil {
+		groups := make([]int, len(c.Groups))
+		for i, g := range c.Groups {
+			groups[i] = int(g)
+		}
+
+		err = syscall.Setgroups(groups)
+		if err != nil {
+			fail("set groups %v: %s", c.Groups, err)
+		}
+
+		err = syscall.Setgid(int(c.Gid))
+		if err != nil {
+			fail("set gid %d: %s", c.Gid, err)
+		}
+
+		err = syscall.Setuid(int(c.Uid))
+		if err != nil {
+			fail("set uid %d: %s", c.Uid, err)
+		}
+	}
+
+	env := make([]string, 0, len(os.Environ()))
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, shimEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+
+	err = syscall.Exec(spec.Cmd, os.Args, env)
+
+	fail("fork/exec %s: %s", spec.Cmd, err)
+}
@@ -0,0 +1,93 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes, in text it is written as a plain number or with
+// a unit suffix such as 512MiB, 100MB or 1G.
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+func ParseSize(s string) (Size, error) {
+	text := strings.TrimSpace(s)
+
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(text), strings.ToUpper(unit.suffix)) {
+			text = strings.TrimSpace(text[:len(text)-len(unit.suffix)])
+			factor = unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+
+	return Size(n * float64(factor)), nil
+}
+
+func (s Size) String() string {
+	for _, unit := range sizeUnits[:4] {
+		if s != 0 && int64(s)%unit.factor == 0 && int64(s)/unit.factor < 1024 {
+			return fmt.Sprintf("%d%s", int64(s)/unit.factor, unit.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(s), 10)), nil
+}
+
+func (s *Size) UnmarshalText(text []byte) error {
+	size, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = size
+
+	return nil
+}
+
+// UnmarshalJSON takes a plain number of bytes besides the text form.
+func (s *Size) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		err := json.Unmarshal(data, &text)
+		if err != nil {
+			return err
+		}
+		data = []byte(text)
+	}
+
+	return s.UnmarshalText(data)
+}
@@ -0,0 +1,82 @@
+package process
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Size
+		invalid bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"512MiB", 512 << 20, false},
+		{"512mib", 512 << 20, false},
+		{"100MB", 100 * 1000 * 1000, false},
+		{"1G", 1 << 30, false},
+		{"1.5K", 1536, false},
+		{" 2 KiB ", 2048, false},
+		{"10B", 10, false},
+		{"", 0, true},
+		{"MiB", 0, true},
+		{"-1", 0, true},
+		{"ten", 0, true},
+	}
+
+	for _, test := range tests {
+		size, err := ParseSize(test.s)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want an error", test.s, size)
+			}
+			continue
+		}
+		if err != nil || size != test.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", test.s, size, err, test.want)
+		}
+	}
+}
+
+func TestSizeJSON(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    Size
+		invalid bool
+	}{
+		{`{"max_size": 1048576}`, 1 << 20, false},
+		{`{"max_size": "1048576"}`, 1 << 20, false},
+		{`{"max_size": "1MiB"}`, 1 << 20, false},
+		{`{"max_size": null}`, 0, false},
+		{`{}`, 0, false},
+		{`{"max_size": "a lot"}`, 0, true},
+		{`{"max_size": -1}`, 0, true},
+	}
+
+	for _, test := range tests {
+		var rotation Rotation
+		err := json.Unmarshal([]byte(test.data), &rotation)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: max size %d, want an error", test.data, rotation.MaxSize)
+			}
+			continue
+		}
+		if err != nil || rotation.MaxSize != test.want {
+			t.Errorf("%s: max size %d, %v, want %d", test.data, rotation.MaxSize, err, test.want)
+			continue
+		}
+
+		// what is written is read back the same.
+		data, err := json.Marshal(&rotation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Rotation
+		if err := json.Unmarshal(data, &back); err != nil || back.MaxSize != rotation.MaxSize {
+			t.Errorf("%s: round trip through %s gives %d, %v", test.data, data, back.MaxSize, err)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package process
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultSocket is the unix socket of the service when none is given,
+// /run/process.sock for root, otherwise in the runtime dir of the user.
+func DefaultSocket() (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", fmt.Errorf("%w, give the unix socket with --address", err)
+	}
+
+	return filepath.Join(dir, "process.sock"), nil
+}
+
+// runtimeDir is /run for root, otherwise the runtime dir of the user. There is
+// no fallback to a shared dir such as /tmp, where another user could take a
+// path first.
+func runtimeDir() (string, error) {
+	if os.Geteuid() == 0 {
+		return "/run", nil
+	}
+
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	return "", fmt.Errorf("XDG_RUNTIME_DIR is not set")
+}
+
+// CheckSocketOwner returns an error unless the socket at path belongs to root
+// or the current user, a socket of anyone else would receive the requests
+// and the token of the client.
+func CheckSocketOwner(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("socket %s: unknown owner", path)
+	}
+
+	if stat.Uid != 0 && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket %s belongs to uid %d, not to root or the current user", path, stat.Uid)
+	}
+
+	return nil
+}
+
+// ListenSocket listens on the unix socket at path with the given mode and,
+// unless empty, owner as user[:group]. A socket left behind by a service that
+// is gone is removed, one that still accepts connections is an error. The
+// socket is removed again when the listener is closed.
+func ListenSocket(path string, mode os.FileMode, owner string) (net.Listener, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		name, group := owner, ""
+		if i := strings.Index(owner, ":"); i >= 0 {
+			name, group = owner[:i], owner[i+1:]
+		}
+
+		if name != "" {
+			u, err := lookupUser(name)
+			if err != nil {
+				return nil, err
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+
+		if group != "" {
+			g, err := lookupGroup(group)
+			if err != nil {
+				return nil, err
+			}
+			gid = int(g)
+		}
+	}
+
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.Mode()&os.ModeSocket == 0:
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	case err == nil:
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("socket %s is in use by another service", path)
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// connecting needs write permission, which the umask leaves to the owner
+	// at most until the mode is set.
+	err = os.Chmod(path, mode)
+	if err == nil && (uid >= 0 || gid >= 0) {
+		err = os.Lchown(path, uid, gid)
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("set socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+// PeerCredential is the user of a unix socket peer, as the kernel reports it.
+type PeerCredential struct {
+	PID int
+	UID int
+	GID int
+}
+
+var operations = []string{"list", "start", "stop", "kill", "restart", "signal", "apply", "logs", "rotate", "wait", "audit"}
+
+// PeerRule grants operations to unix socket peers by user or group, a rule
+// for a group matches the primary and the supplementary groups of the peer.
+type PeerRule struct {
+	UID        int
+	GID        int
+	Operations []string
+}
+
+// ParsePeerRule parses user:name=operations, uid:id=operations,
+// group:name=operations or gid:id=operations, where operations is a comma
+// separated list of rpc operations or * for all of them.
+func ParsePeerRule(s string) (*PeerRule, error) {
+	i := strings.Index(s, "=")
+	j := strings.Index(s, ":")
+	if i < 0 || j < 0 || j > i {
+		return nil, fmt.Errorf("invalid peer rule: %q, want user:name=operations", s)
+	}
+
+	kind, name := s[:j], s[j+1:i]
+
+	rule := &PeerRule{UID: -1, GID: -1}
+
+	switch kind {
+	case "user", "uid":
+		u, err := lookupUser(name)
+		if err != nil {
+			return nil, err
+		}
+		rule.UID, _ = strconv.Atoi(u.Uid)
+	case "group", "gid":
+		gid, err := lookupGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		rule.GID = int(gid)
+	default:
+		return nil, fmt.Errorf("invalid peer rule: %q, want user, uid, group or gid", s)
+	}
+
+	for _, operation := range strings.Split(s[i+1:], ",") {
+		operation = strings.ToLower(strings.TrimSpace(operation))
+		if operation != "*" && !containsString(operations, operation) {
+			return nil, fmt.Errorf("invalid peer rule: %q, unknown operation %s", s, operation)
+		}
+		rule.Operations = append(rule.Operations, operation)
+	}
+
+	return rule, nil
+}
+
+func (r *PeerRule) grants(uid int, gids []int, operation string) bool {
+	if r.UID >= 0 && r.UID != uid {
+		return false
+	}
+	if r.GID >= 0 && !containsInt(gids, r.GID) {
+		return false
+	}
+	return containsString(r.Operations, "*") || containsString(r.Operations, operation)
+}
+
+// peerGroups returns the primary group of the peer and the supplementary
+// groups of its user.
+func peerGroups(c *PeerCredential) []int {
+	gids := []int{c.GID}
+
+	u, err := user.LookupId(strconv.Itoa(c.UID))
+	if err != nil {
+		return gids
+	}
+
+	groups, err := u.GroupIds()
+	if err != nil {
+		return gids
+	}
+
+	for _, group := range groups {
+		gid, err := strconv.Atoi(group)
+		if err == nil && gid != c.GID {
+			gids = append(gids, gid)
+		}
+	}
+
+	return gids
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(s []int, v int) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
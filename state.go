@@ -0,0 +1,208 @@
+package process
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const stateVersion = 1
+
+type definition struct {
+	UUID    string   `json:"uuid"`
+	Name    string   `json:"name,omitempty"`
+	Source  string   `json:"source,omitempty"`
+	Dir     string   `json:"dir"`
+	Cmd     string   `json:"cmd"`
+	Argv    []string `json:"argv"`
+	Env     []string `json:"env"`
+	Files   []string `json:"files"`
+	Restart bool     `json:"restart"`
+	Cron    string   `json:"cron"`
+
+	Labels   map[string]string `json:"labels,omitempty"`
+	Capture  bool              `json:"capture,omitempty"`
+	Rotation *Rotation         `json:"rotation,omitempty"`
+
+	RestartPolicy *RestartPolicy `json:"restart_policy,omitempty"`
+	HealthCheck   *HealthCheck   `json:"health_check,omitempty"`
+	Readiness     *Readiness     `json:"readiness,omitempty"`
+	DependsOn     []*Dependency  `json:"depends_on,omitempty"`
+	KillMode      string         `json:"kill_mode,omitempty"`
+	Session       bool           `json:"session,omitempty"`
+	User          string         `json:"user,omitempty"`
+	Group         string         `json:"group,omitempty"`
+	Groups        []string       `json:"groups,omitempty"`
+	Limits        []*Rlimit      `json:"limits,omitempty"`
+	Cgroup        *Cgroup        `json:"cgroup,omitempty"`
+
+	MaxMemoryRestart Size          `json:"max_memory_restart,omitempty"`
+	MaxUptime        time.Duration `json:"max_uptime,omitempty"`
+
+	// Stopped records an explicit stop, an unless-stopped process stays
+	// down when the manager comes back.
+	Stopped bool `json:"stopped,omitempty"`
+
+	// Pid and StartTime identify a running instance, a later manager adopts
+	// it instead of starting a duplicate.
+	Pid       int    `json:"pid,omitempty"`
+	StartTime uint64 `json:"start_time,omitempty"`
+}
+
+type state struct {
+	Version   int           `json:"version"`
+	Processes []*definition `json:"processes"`
+}
+
+func newDefinition(uuid string, attributes *Attributes) *definition {
+	return &definition{
+		UUID:     uuid,
+		Name:     attributes.name,
+		Source:   attributes.source,
+		Dir:      attributes.dir,
+		Cmd:      attributes.cmd,
+		Argv:     attributes.argv,
+		Env:      attributes.env,
+		Files:    attributes.files,
+		Restart:  attributes.restart,
+		Cron:     attributes.cron,
+		Labels:   attributes.labels,
+		Capture:  attributes.capture,
+		Rotation: attributes.rotation,
+
+		RestartPolicy: attributes.restartPolicy,
+		HealthCheck:   attributes.healthCheck,
+		Readiness:     attributes.readiness,
+		DependsOn:     attributes.dependsOn,
+		KillMode:      attributes.kill,
+		Session:       attributes.session,
+		User:          attributes.user,
+		Group:         attributes.group,
+		Groups:        attributes.groups,
+		Limits:        attributes.limits,
+		Cgroup:        attributes.cgroup,
+
+		MaxMemoryRestart: attributes.maxMemoryRestart,
+		MaxUptime:        attributes.maxUptime,
+	}
+}
+
+func (d *definition) attributes() *Attributes {
+	return &Attributes{
+		name:     d.Name,
+		source:   d.Source,
+		dir:      d.Dir,
+		cmd:      d.Cmd,
+		argv:     d.Argv,
+		env:      d.Env,
+		files:    d.Files,
+		restart:  d.Restart,
+		cron:     d.Cron,
+		labels:   d.Labels,
+		capture:  d.Capture,
+		rotation: d.Rotation,
+
+		restartPolicy: d.RestartPolicy,
+		healthCheck:   d.HealthCheck,
+		readiness:     d.Readiness,
+		dependsOn:     d.DependsOn,
+		kill:          d.KillMode,
+		session:       d.Session,
+		user:          d.User,
+		group:         d.Group,
+		groups:        d.Groups,
+		limits:        d.Limits,
+		cgroup:        d.Cgroup,
+
+		maxMemoryRestart: d.MaxMemoryRestart,
+		maxUptime:        d.MaxUptime,
+	}
+}
+
+// readState loads the state file. A missing file yields an empty state, a
+// corrupt one is moved aside so the next write starts from a clean slate. A
+// state of a newer version is an error, a downgraded service must not replace
+// it; older versions would be migrated here, there are none yet.
+func readState(path string) (*state, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &state{Version: stateVersion}, nil
+		}
+		return nil, err
+	}
+
+	s := &state{}
+
+	err = json.Unmarshal(data, s)
+	if err == nil && s.Version > stateVersion {
+		return nil, fmt.Errorf("state %s has version %d, newer than the supported %d", path, s.Version, stateVersion)
+	}
+	if err == nil && s.Version < 1 {
+		err = fmt.Errorf("unsupported state version: %d", s.Version)
+	}
+	if err != nil {
+		corrupt := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+
+		logrus.WithField("state", path).WithField("corrupt", corrupt).WithError(err).Warn("state corrupt, discard")
+
+		err = os.Rename(path, corrupt)
+		if err != nil {
+			return nil, err
+		}
+
+		return &state{Version: stateVersion}, nil
+	}
+
+	return s, nil
+}
+
+// writeState replaces the state file atomically, a crash while writing leaves
+// either the previous or the new content on disk, never a mix of both.
+func writeState(path string, s *state) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
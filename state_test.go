@@ -0,0 +1,56 @@
+package process
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadState(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		processes int
+		invalid   bool
+		discarded bool
+	}{
+		{"missing", "", 0, false, false},
+		{"current", `{"version":1,"processes":[{"uuid":"a","cmd":"/bin/true"}]}`, 1, false, false},
+		{"corrupt", `{"version":1,"processes":[`, 0, false, true},
+		{"no version", `{"processes":[{"uuid":"a","cmd":"/bin/true"}]}`, 0, false, true},
+		{"newer version", `{"version":2,"processes":[{"uuid":"a","cmd":"/bin/true"}]}`, 0, true, false},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "state.json")
+		if test.data != "" {
+			if err := ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s, err := readState(path)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if len(s.Processes) != test.processes {
+			t.Errorf("%s: %d processes, want %d", test.name, len(s.Processes), test.processes)
+		}
+
+		corrupt, _ := filepath.Glob(path + ".corrupt-*")
+		if test.discarded != (len(corrupt) == 1) {
+			t.Errorf("%s: moved aside to %v", test.name, corrupt)
+		}
+
+		// a state that is not discarded stays where it is.
+		_, err = os.Stat(path)
+		if kept := err == nil; kept != (test.data != "" && !test.discarded) {
+			t.Errorf("%s: state file kept %v", test.name, kept)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package process
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	sampleInterval = time.Second * 2
+
+	// clockTicks is USER_HZ, the unit of the cpu times in /proc, it is 100
+	// on every platform Go supports.
+	clockTicks = 100
+)
+
+type procSample struct {
+	cpuTicks   uint64
+	threads    int
+	rss        uint64
+	fds        int
+	readBytes  uint64
+	writeBytes uint64
+}
+
+// Stats is the resource usage of a running process, summed over its
+// descendants when the manager samples those too. CPU is in percent of one
+// CPU over the last sample interval, CPUTime the cpu time used so far and
+// OwnCPUTime that of the process alone.
+type Stats struct {
+	CPU        float64
+	CPUTime    time.Duration
+	OwnCPUTime time.Duration
+	RSS        Size
+	Threads    int
+	FDs        int
+	ReadBytes  Size
+	WriteBytes Size
+	Processes  int
+	SampledAt  time.Time
+}
+
+func (m *Manager) sampleLoop(ctx context.Context) {
+	t := time.NewTicker(sampleInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		m.lock.Lock()
+		processes := make([]*Process, 0, len(m.processes))
+		for _, process := range m.processes {
+			processes = append(processes, process)
+		}
+		m.lock.Unlock()
+
+		for _, process := range processes {
+			process.sample(m.statsDescendants)
+
+			process.m.Lock()
+			recycle := process.attributes.maxMemoryRestart > 0 || process.attributes.maxUptime > 0
+			process.m.Unlock()
+
+			if recycle {
+				go process.recycle()
+			}
+		}
+	}
+}
+
+// sample takes the resource usage of the current run, the cpu usage is the
+// difference to the previous sample of the same run.
+func (p *Process) sample(tree bool) {
+	p.m.Lock()
+	if p.process == nil || p.status != statusRunning && p.status != statusStopping {
+		p.stats = nil
+		p.m.Unlock()
+		return
+	}
+	pid, run := p.process.Pid, p.runs
+	p.m.Unlock()
+
+	pids := []int{pid}
+	if tree {
+		found, _ := descendants(pid)
+		for _, s := range found {
+			pids = append(pids, s.pid)
+		}
+	}
+
+	now := time.Now()
+	stats := &Stats{SampledAt: now}
+
+	var ticks uint64
+	for _, pid := range pids {
+		s, err := readProcSample(pid)
+		if err != nil {
+			continue
+		}
+
+		ticks += s.cpuTicks
+		if pid == pids[0] {
+			stats.OwnCPUTime = time.Duration(s.cpuTicks) * time.Second / clockTicks
+		}
+		stats.RSS += Size(s.rss)
+		stats.Threads += s.threads
+		stats.FDs += s.fds
+		stats.ReadBytes += Size(s.readBytes)
+		stats.WriteBytes += Size(s.writeBytes)
+		stats.Processes++
+	}
+
+	stats.CPUTime = time.Duration(ticks) * time.Second / clockTicks
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.runs != run {
+		return
+	}
+
+	if stats.Processes == 0 {
+		p.stats = nil
+		return
+	}
+
+	// a descendant that exited takes its cpu time along, the sum may drop.
+	if p.stats != nil && p.sampleRun == run && ticks >= p.sampleTicks {
+		elapsed := now.Sub(p.stats.SampledAt).Seconds()
+		if elapsed > 0 {
+			stats.CPU = float64(ticks-p.sampleTicks) / clockTicks / elapsed * 100
+		}
+	}
+
+	p.stats = stats
+	p.sampleTicks = ticks
+	p.sampleRun = run
+}
+
+// uptime returns how long the current run has been running, from the start
+// time of the process so it also holds for adopted processes. Called with p.m
+// held.
+func (p *Process) uptime() time.Duration {
+	if p.process == nil || p.status != statusRunning && p.status != statusStopping {
+		return 0
+	}
+
+	if p.startTime > 0 {
+		started, err := procStarted(p.startTime)
+		if err == nil {
+			return time.Since(started)
+		}
+	}
+
+	return time.Since(p.startedAt)
+}